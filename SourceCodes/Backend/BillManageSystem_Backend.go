@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,52 +10,432 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/crypto/bcrypt"
 
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/peer"
 	"github.com/hyperledger/fabric-sdk-go/pkg/core/config"
 	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
 )
 
+const (
+	// 用户角色
+	// User roles
+	Role_Admin   = "admin"
+	Role_Bank    = "bank"
+	Role_Company = "company"
+
+	// 链下用户数据文件
+	// The off-chain user store file
+	userStorePath = "users.json"
+
+	// 登录会话有效期
+	// How long a login session is valid
+	sessionTTL = 2 * time.Hour
+
+	// 登记身份证书的目录，每个公司一个子目录 enrollment/<CompanyId>/msp
+	// The enrollment directory, one sub directory per company: enrollment/<CompanyId>/msp
+	enrollmentDir = "enrollment"
+
+	// 未指定 mspid 文件时使用的默认MSP
+	// The MSP used when the enrollment has no mspid file
+	defaultMSPID = "Org2MSP"
+
+	// 银行的公司ID，初始化账本时使用银行身份
+	// The bank's company ID, the ledger is initialized as the bank
+	bankCompanyID = "bank"
+
+	// 管理员的公司ID，管理员以自己登记的身份签名，链码中以 admin 角色登记
+	// The admin's company ID. The admin signs with its own enrolled identity, registered with the admin role on the ledger
+	adminCompanyID = "admin"
+
+	// 票据链码所在的通道及链码名称
+	// The channel and the name of the bill chaincode
+	channelName   = "mychannel"
+	chaincodeName = "fabcar"
+
+	// 票据搜索默认每页数量
+	// The page size of bill searches when none is given
+	defaultPageSize = 20
+
+	// 默认币种，人民币
+	// The default currency, renminbi
+	defaultCurrency = "CNY"
+
+	// 票据金额上限（分），与链码一致
+	// The largest amount of a bill in fen, the same as the chaincode's
+	maxAmountFen = 1000000000000
+
+	// 票据最长期限（月），与链码一致
+	// The longest term of a bill in months, the same as the chaincode's
+	maxBillTermMonths = 12
+
+	// 上下文中保存登录会话的键
+	// The context key of the login session
+	principalKey = "principal"
+)
+
 // Personal info struct
 type SignInfo struct {
-	Username    string `form:"Username" json:"Username"`       // 用户名
-	Password    string `form:"Password" json:"Password"`       // 密码
-	CompanyName string `form:"CompanyName" json:"CompanyName"` // 公司名称
-	CompanyId   string `form:"CompanyId" json:"CompanyId"`     //  公司ID
+	Username    string `form:"Username" json:"Username" binding:"required,max=64"`         // 用户名
+	Password    string `form:"Password" json:"Password" binding:"required,max=72"`         // 密码
+	CompanyName string `form:"CompanyName" json:"CompanyName" binding:"omitempty,max=128"` // 公司名称
+	CompanyId   string `form:"CompanyId" json:"CompanyId" binding:"omitempty,partyid"`     //  公司ID
+}
+
+// 链下保存的用户记录，只保存密码的 bcrypt 哈希
+// User record kept off-chain, only the bcrypt hash of the password is stored
+type UserRecord struct {
+	Username     string `json:"Username"`     // 用户名
+	PasswordHash string `json:"PasswordHash"` // 密码哈希
+	CompanyName  string `json:"CompanyName"`  // 公司名称
+	CompanyId    string `json:"CompanyId"`    // 公司ID
+	Role         string `json:"Role"`         // 角色 admin / bank / company
+}
+
+// 返回给前端的用户信息，不包含任何密码数据
+// User info returned to the front end, without any secret
+type UserInfo struct {
+	Username    string `json:"Username"`    // 用户名
+	CompanyName string `json:"CompanyName"` // 公司名称
+	CompanyId   string `json:"CompanyId"`   // 公司ID
+	Role        string `json:"Role"`        // 角色
 }
 
 // Bill info struct
+// binding 规则用于发布票据时的校验
+// The binding rules validate the bill when it is issued
 type Bill struct {
 	//票据基本信息
-	BillInfoID        string `json:"BillInfoID"`        //票据号码  Bill ID
-	BillInfoMoney     string `json:"BillInfoMoney"`     //票据金额  Bill Amount
-	BillInfoType      string `json:"BillInfoType"`      //票据类型	Bill Type
-	BillInfoIssueDate string `json:"BillInfoIssueDate"` //票据出票日期  Bill issue date
-	BillInfoDueDate   string `json:"BillInfoDueDate"`   //票据到期日期  Bill due date
+	BillInfoID        string `json:"BillInfoID" binding:"required,billid"`                     //票据号码  Bill ID
+	BillInfoMoney     string `json:"BillInfoMoney" binding:"required,amount"`                  //票据金额  Bill Amount
+	BillInfoCurrency  string `json:"BillInfoCurrency" binding:"omitempty,currency"`            //币种，默认人民币  Currency, CNY by default
+	BillInfoFen       int64  `json:"-" form:"-"`                                               //金额（分），用于精确计算合计  Amount in fen, for exact totals
+	BillInfoType      string `json:"BillInfoType" binding:"required,max=32"`                   //票据类型	Bill Type
+	BillInfoIssueDate string `json:"BillInfoIssueDate" binding:"required,datetime=2006-01-02"` //票据出票日期  Bill issue date
+	BillInfoDueDate   string `json:"BillInfoDueDate" binding:"required,datetime=2006-01-02"`   //票据到期日期  Bill due date
+	NonTransferable   bool   `json:"NonTransferable"`                                          //不得转让，不能再背书或贴现  "Not transferable", the bill can no longer be endorsed or discounted
 	//出票人信息  People info (who public this bill)
-	PubBillID   string `json:"PubBillID"`   //出票人证件号码  Personal ID
-	PubBillName string `json:"PubBillName"` //出票人名称	Personal Name
+	PubBillID   string `json:"PubBillID" binding:"required,partyid"`   //出票人证件号码  Personal ID
+	PubBillName string `json:"PubBillName" binding:"required,max=128"` //出票人名称	Personal Name
 	//承兑人信息  People info (who pay for this bill)
-	PayBillID   string `json:"PayBillID"`   //承兑人证件号码  Personal ID
-	PayBillName string `json:"PayBillName"` //承兑人名称  Personal Name
+	PayBillID   string `json:"PayBillID" binding:"required,partyid"`   //承兑人证件号码  Personal ID
+	PayBillName string `json:"PayBillName" binding:"required,max=128"` //承兑人名称  Personal Name
 	//收款人信息  People info (who receive the money)
-	AcceptBillID   string `json:"AcceptBillID"`   //收款人证件号码  Personal ID
-	AcceptBillName string `json:"AcceptBillName"` //收款人名称  Personal Name
+	AcceptBillID   string `json:"AcceptBillID" binding:"required,partyid"`   //收款人证件号码  Personal ID
+	AcceptBillName string `json:"AcceptBillName" binding:"required,max=128"` //收款人名称  Personal Name
 	//持票人信息  People info (who own the bill)
-	HoldBillID   string `json:"HoldBillID"`   //持票人证件号码  Personal ID
-	HoldBillName string `json:"HoldBillName"` //持票人名称  Personal Name
+	HoldBillID   string `json:"HoldBillID" binding:"required,partyid"`   //持票人证件号码  Personal ID
+	HoldBillName string `json:"HoldBillName" binding:"required,max=128"` //持票人名称  Personal Name
 	//背书操作--信息	Attributes for endorsement
-	EndorsedID   string `json:"EndorsedID"`   // 被背书人证件号码   Personal ID
-	EndorsedName string `json:"EndorsedName"` // 被背书人名称  Personal Name
-	Message      string `json:"Message"`      // 操作提示信息  Message for User
-	State        string `json:"State"`        //票据状态  Bill State
+	EndorsedID          string        `json:"EndorsedID"`             // 被背书人证件号码   Personal ID
+	EndorsedName        string        `json:"EndorsedName"`           // 被背书人名称  Personal Name
+	EndorsedRestrictive bool          `json:"EndorsedRestrictive"`    // 申请中的背书是否记载"不得转让"  Whether the pending endorsement is restrictive
+	Endorsements        []Endorsement `json:"Endorsements,omitempty"` // 背书记录，按时间顺序  The endorsement chain, oldest first
+	Message             string        `json:"Message"`                // 操作提示信息  Message for User
+	State               string        `json:"State"`                  //票据状态  Bill State
+	//最近一次修改信息  Who changed the bill last
+	UpdatedBy    string `json:"UpdatedBy"`    // 最近修改者的公司ID  Company ID of the last invoker
+	UpdatedByMSP string `json:"UpdatedByMSP"` // 最近修改者的MSP  MSP of the last invoker
+	//提示付款信息  Presentment for payment
+	PresentedAt    string `json:"PresentedAt"`    // 提示付款时间  When the bill was presented
+	SettledAt      string `json:"SettledAt"`      // 付款或拒绝付款时间  When the payment was made or refused
+	DishonorReason string `json:"DishonorReason"` // 拒绝付款理由  Why the payment was refused
+	//追索信息  Recourse
+	RecourseID   string           `json:"RecourseID"`          // 当前被追索人证件号码  Personal ID of the party currently claimed against
+	RecourseName string           `json:"RecourseName"`        // 当前被追索人名称  Personal Name of the party currently claimed against
+	Recourses    []RecourseRecord `json:"Recourses,omitempty"` // 追索记录  Every recourse claim made on the bill
+}
+
+// 一次背书记录，贴现也视为向银行的背书
+// One endorsement in the chain; a discount is recorded as an endorsement to the bank
+type Endorsement struct {
+	Type         string `json:"type"`         // endorse / discount
+	EndorserID   string `json:"endorserId"`   // 背书人证件号码  Personal ID of the endorser
+	EndorserName string `json:"endorserName"` // 背书人名称  Personal Name of the endorser
+	EndorseeID   string `json:"endorseeId"`   // 被背书人证件号码  Personal ID of the endorsee
+	EndorseeName string `json:"endorseeName"` // 被背书人名称  Personal Name of the endorsee
+	Restrictive  bool   `json:"restrictive"`  // 是否记载"不得转让"  Whether the endorser wrote "not transferable"
+	Timestamp    string `json:"timestamp"`    // 签收时间（UTC，RFC3339）  When the endorsee signed, in UTC RFC3339
+	TxID         string `json:"txId"`         // 签收交易ID  Transaction ID of the signature
+}
+
+// 一次追索记录
+// One recourse claim
+type RecourseRecord struct {
+	LiableID   string `json:"liableId"`   // 被追索人证件号码  Personal ID of the liable party
+	LiableName string `json:"liableName"` // 被追索人名称  Personal Name of the liable party
+	FromState  string `json:"fromState"`  // 发起追索时的票据状态  The bill state the claim was made from
+	Status     string `json:"status"`     // claimed / agreed / refused / settled
+	Reason     string `json:"reason"`     // 拒绝理由  Why the claim was refused
+	ClaimedAt  string `json:"claimedAt"`  // 发起时间  When the claim was made
+	AnsweredAt string `json:"answeredAt"` // 同意或拒绝时间  When the claim was agreed or refused
+	SettledAt  string `json:"settledAt"`  // 结清时间  When the holder confirmed the payment
+}
+
+// 可被追索的当事人
+// A party the holder can claim against
+type LiableParty struct {
+	ID   string `json:"id"`   // 证件号码  Personal ID
+	Name string `json:"name"` // 名称  Personal Name
+	Role string `json:"role"` // drawer / acceptor / endorser
+}
+
+// 链码中的金额，以分为单位的整数及币种
+// An amount as stored by the chaincode: an integer number of fen and the currency
+type Amount struct {
+	Fen      int64  `json:"fen"`               // 金额（分）  Amount in fen
+	Currency string `json:"currency"`          // 币种代码  Currency code
+	Invalid  string `json:"invalid,omitempty"` // 无法识别的旧版本金额  A legacy amount which could not be parsed
+}
+
+// 解码票据：前端传来的金额为十进制字符串，链码返回的金额为分及币种，统一转换为显示字符串
+// Decode a bill. The front end sends the amount as a decimal string while the chaincode returns
+// fen and currency, both end up as the display string
+func (bill *Bill) UnmarshalJSON(data []byte) error {
+	type plainBill Bill
+	var decoded struct {
+		plainBill
+		BillInfoMoney json.RawMessage `json:"BillInfoMoney"`
+	}
+	err := json.Unmarshal(data, &decoded)
+	if err != nil {
+		return err
+	}
+	*bill = Bill(decoded.plainBill)
+	if len(decoded.BillInfoMoney) == 0 || string(decoded.BillInfoMoney) == "null" {
+		return nil
+	}
+	if decoded.BillInfoMoney[0] == '"' {
+		return json.Unmarshal(decoded.BillInfoMoney, &bill.BillInfoMoney)
+	}
+	var amount Amount
+	err = json.Unmarshal(decoded.BillInfoMoney, &amount)
+	if err != nil {
+		return err
+	}
+	bill.BillInfoFen = amount.Fen
+	bill.BillInfoCurrency = amount.Currency
+	// 被标记的旧票据原样显示其金额
+	// A flagged legacy bill shows its amount as stored
+	if amount.Invalid != "" {
+		bill.BillInfoMoney = amount.Invalid
+		return nil
+	}
+	// 作废的票据金额已被清除
+	// A failed bill has its amount wiped
+	if amount.Fen > 0 {
+		bill.BillInfoMoney = formatFen(amount.Fen)
+	}
+	return nil
+}
+
+// 将十进制金额字符串转换为分，最多两位小数
+// Convert a decimal amount with at most two places to fen
+func parseFen(value string) (int64, error) {
+	if !amountPattern.MatchString(value) {
+		return 0, fmt.Errorf("malformed amount %q", value)
+	}
+	parts := strings.SplitN(value, ".", 2)
+	yuan, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || yuan > maxAmountFen/100 {
+		return 0, fmt.Errorf("amount %q is too large", value)
+	}
+	fen := yuan * 100
+	if len(parts) == 2 {
+		cents, _ := strconv.ParseInt((parts[1] + "0")[:2], 10, 64)
+		fen += cents
+	}
+	return fen, nil
+}
+
+// 以两位小数显示金额
+// Format an amount in fen as a decimal string with two places
+func formatFen(fen int64) string {
+	return fmt.Sprintf("%d.%02d", fen/100, fen%100)
+}
+
+// 按币种计算票据金额合计，以分为单位累加，不会产生舍入误差
+// Sum the amounts of the bills per currency. The sum is done in fen, so nothing is rounded
+func billTotals(bills []Bill) map[string]string {
+	sums := map[string]int64{}
+	for _, bill := range bills {
+		if bill.BillInfoFen > 0 {
+			sums[bill.BillInfoCurrency] += bill.BillInfoFen
+		}
+	}
+	totals := map[string]string{}
+	for currency, fen := range sums {
+		totals[currency] = formatFen(fen)
+	}
+	return totals
+}
+
+// 票据历史记录中的一条，包含交易信息及当时的票据
+// One entry of a bill's history: the transaction metadata and the bill as written by it
+type HistoryEntry struct {
+	TxID      string `json:"txId"`      // 交易ID  Transaction ID
+	Timestamp string `json:"timestamp"` // 交易时间（UTC，RFC3339）  Transaction time in UTC RFC3339
+	IsDelete  bool   `json:"isDelete"`  // 是否为删除操作  Whether the transaction deleted the bill
+	MSPID     string `json:"mspId"`     // 调用者的MSP  MSP of the invoker
+	Invoker   string `json:"invoker"`   // 调用者的公司ID  Company ID of the invoker
+	Value     *Bill  `json:"value"`     // 交易写入的票据，删除时为空  The bill written, nil when deleted
+}
+
+// 只需要票据编号的请求，用于承兑、贴现、背书签收等操作
+// A request with the bill's id only, for accepting, discounting, signing endorsements...
+type BillIDRequest struct {
+	BillInfoID string `form:"BillInfoID" json:"BillInfoID" binding:"required,billid"` //票据号码  Bill ID
+}
+
+// 更换承兑人请求
+// Request to change the pay user
+type ChangePayRequest struct {
+	BillInfoID  string `form:"BillInfoID" json:"BillInfoID" binding:"required,billid"`    //票据号码  Bill ID
+	PayBillID   string `form:"PayBillID" json:"PayBillID" binding:"required,partyid"`     //承兑人证件号码  Personal ID
+	PayBillName string `form:"PayBillName" json:"PayBillName" binding:"required,max=128"` //承兑人名称  Personal Name
+}
+
+// 申请背书请求
+// Request to endorse a bill
+type EndorseRequest struct {
+	BillInfoID   string `form:"BillInfoID" json:"BillInfoID" binding:"required,billid"`      //票据号码  Bill ID
+	EndorsedID   string `form:"EndorsedID" json:"EndorsedID" binding:"required,partyid"`     // 被背书人证件号码   Personal ID
+	EndorsedName string `form:"EndorsedName" json:"EndorsedName" binding:"required,max=128"` // 被背书人名称  Personal Name
+	Restrictive  bool   `form:"Restrictive" json:"Restrictive"`                              // 是否记载"不得转让"  Mark the endorsement "not transferable"
+}
+
+// 拒绝付款请求
+// Request to refuse the payment of a presented bill
+type DishonorRequest struct {
+	BillInfoID string `form:"BillInfoID" json:"BillInfoID" binding:"required,billid"` //票据号码  Bill ID
+	Reason     string `form:"Reason" json:"Reason" binding:"required,max=256"`        // 拒绝付款理由  Why the payment is refused
+}
+
+// 发起追索请求
+// Request to claim recourse against a liable party
+type RecourseRequest struct {
+	BillInfoID string `form:"BillInfoID" json:"BillInfoID" binding:"required,billid"` //票据号码  Bill ID
+	LiableID   string `form:"LiableID" json:"LiableID" binding:"required,partyid"`    // 被追索人证件号码  Personal ID of the liable party
 }
 
-var contract *gateway.Contract
+// 账本上的公司登记信息
+// A company registry record on the ledger
+type Company struct {
+	CompanyId   string `json:"CompanyId"`   // 公司ID  Company ID
+	CompanyName string `json:"CompanyName"` // 公司名称  Company name
+	Role        string `json:"Role"`        // 角色  Role
+	MSPID       string `json:"MSPID"`       // 签发该公司证书的MSP  The MSP issuing the company's certificates
+}
+
+// 登记公司请求，未指定MSP时登记在默认MSP下
+// Request to register a company, under the default MSP when none is given
+type RegisterCompanyRequest struct {
+	CompanyId   string `form:"CompanyId" json:"CompanyId" binding:"required,partyid"`        // 公司ID  Company ID
+	CompanyName string `form:"CompanyName" json:"CompanyName" binding:"required,max=128"`    // 公司名称  Company name
+	Role        string `form:"Role" json:"Role" binding:"required,oneof=bank company admin"` // 角色  Role
+	MSPID       string `form:"MSPID" json:"MSPID" binding:"omitempty,max=64"`                // 签发该公司证书的MSP  The MSP issuing the company's certificates
+}
+
+// 更正被标记的旧票据金额请求
+// Request to correct the amount of a flagged legacy bill
+type CorrectBillAmountRequest struct {
+	BillInfoID       string `form:"BillInfoID" json:"BillInfoID" binding:"required,billid"`                //票据号码  Bill ID
+	BillInfoMoney    string `form:"BillInfoMoney" json:"BillInfoMoney" binding:"required,amount"`          //票据金额  Bill Amount
+	BillInfoCurrency string `form:"BillInfoCurrency" json:"BillInfoCurrency" binding:"omitempty,currency"` //币种，默认人民币  Currency, CNY by default
+}
+
+// 票据搜索条件，各条件均为可选
+// Bill search filters, every one is optional
+type BillSearch struct {
+	States        []string `form:"states" json:"states" binding:"omitempty,dive,oneof=made public enwaitsign dcwaitsigned waitpay billfail matured overdue presented settled dishonored recourse recourseagreed"` // 票据状态之一  One of these states
+	PartyID       string   `form:"partyId" json:"partyId" binding:"omitempty,partyid"`                                                                                                                            // 任一当事人  Any party of the bill
+	BillType      string   `form:"billType" json:"billType" binding:"max=32"`                                                                                                                                     // 票据类型  Bill type
+	Currency      string   `form:"currency" json:"currency" binding:"omitempty,currency"`                                                                                                                         // 币种  Currency
+	MinAmount     string   `form:"minAmount" json:"minAmount" binding:"omitempty,amount"`                                                                                                                         // 最小金额  Lowest amount
+	MaxAmount     string   `form:"maxAmount" json:"maxAmount" binding:"omitempty,amount"`                                                                                                                         // 最大金额  Highest amount
+	IssueDateFrom string   `form:"issueDateFrom" json:"issueDateFrom" binding:"omitempty,datetime=2006-01-02"`                                                                                                    // 出票日期起  Issued on or after
+	IssueDateTo   string   `form:"issueDateTo" json:"issueDateTo" binding:"omitempty,datetime=2006-01-02"`                                                                                                        // 出票日期止  Issued on or before
+	DueDateFrom   string   `form:"dueDateFrom" json:"dueDateFrom" binding:"omitempty,datetime=2006-01-02"`                                                                                                        // 到期日期起  Due on or after
+	DueDateTo     string   `form:"dueDateTo" json:"dueDateTo" binding:"omitempty,datetime=2006-01-02"`                                                                                                            // 到期日期止  Due on or before
+	IDPrefix      string   `form:"idPrefix" json:"idPrefix" binding:"omitempty,billid"`                                                                                                                           // 票据编号前缀  Bill ID prefix
+	Transferable  *bool    `form:"transferable" json:"transferable,omitempty"`                                                                                                                                    // 是否可以转让  Whether the bill can be transferred
+	SortBy        string   `form:"sortBy" json:"sortBy" binding:"omitempty,oneof=BillInfoID BillInfoIssueDate BillInfoDueDate"`                                                                                   // 排序字段  Field to sort on
+	SortOrder     string   `form:"sortOrder" json:"sortOrder" binding:"omitempty,oneof=asc desc"`                                                                                                                 // 排序方向  Sort order
+}
+
+// 票据搜索请求，搜索条件及分页参数
+// A bill search request: the filters and the page
+type SearchRequest struct {
+	BillSearch
+	PageSize int    `form:"pageSize" json:"pageSize" binding:"omitempty,min=1,max=200"` // 每页数量，默认20  Page size, 20 by default
+	Bookmark string `form:"bookmark" json:"bookmark" binding:"max=4096"`                // 上一页返回的书签  Bookmark returned by the previous page
+}
+
+// 分页参数，从URL查询参数中读取；PageSize 为0时返回全部结果
+// Pagination parameters read from the URL query, all the results are returned when PageSize is 0
+type PageRequest struct {
+	PageSize int    `form:"pageSize" binding:"omitempty,min=1,max=200"` // 每页数量  Page size
+	Bookmark string `form:"bookmark" binding:"max=4096"`                // 上一页返回的书签  Bookmark returned by the previous page
+}
+
+// 分页查询结果
+// One page of bills
+type BillPage struct {
+	Bills               []Bill            `json:"bills"`               // 本页票据  Bills of this page
+	FetchedRecordsCount int32             `json:"fetchedRecordsCount"` // 本页数量  Number of bills fetched
+	Bookmark            string            `json:"bookmark"`            // 下一页的书签  Bookmark of the next page
+	Totals              map[string]string `json:"totals"`              // 本页按币种的金额合计  Totals of this page per currency
+}
+
+// 校验失败的字段
+// A field which failed validation
+type FieldError struct {
+	Field string `json:"field"` // 字段名  Field name
+	Rule  string `json:"rule"`  // 未通过的规则  The failed rule
+	Param string `json:"param"` // 规则参数  Rule parameter
+}
+
+// 按公司缓存的区块链网络连接
+// The Blockchain Network connections, cached per company
+var ledger *LedgerClient
+
+// 链下用户存储
+// The off-chain user store
+var users *UserStore
+
+// 登录会话
+// The login sessions
+var sessions = NewSessionStore(sessionTTL)
 
 func main() {
+	// 打开链下用户存储
+	// Open the off-chain user store
+	var err error
+	users, err = NewUserStore(userStorePath)
+	if err != nil {
+		fmt.Printf("Failed to open user store: %s\n", err)
+		os.Exit(1)
+	}
+	// 注册请求校验规则
+	// Register the request validation rules
+	err = registerValidators()
+	if err != nil {
+		fmt.Printf("Failed to register validators: %s\n", err)
+		os.Exit(1)
+	}
+
 	os.Setenv("DISCOVERY_AS_LOCALHOST", "true")
 	// 创建一个钱包
 	// Build a wallet
@@ -62,15 +444,6 @@ func main() {
 		fmt.Printf("Failed to create wallet: %s\n", err)
 		os.Exit(1)
 	}
-	// 向钱包中添加用户的证书，生成目录等
-	// Add the User Certificate, content... to the wallet
-	if !wallet.Exists("appUser2") {
-		err = populateWallet(wallet)
-		if err != nil {
-			fmt.Printf("Failed to populate wallet contents: %s\n", err)
-			os.Exit(1)
-		}
-	}
 	// 获取链接配置文件
 	// The configuration files
 	ccpPath := filepath.Join(
@@ -82,29 +455,18 @@ func main() {
 		"org2.example.com",
 		"connection-org2.yaml",
 	)
-	// 从钱包中获取身份，创立链接
-	gw, err := gateway.Connect(
-		gateway.WithConfig(config.FromFile(filepath.Clean(ccpPath))),
-		gateway.WithIdentity(wallet, "appUser2"),
-	)
+	// 每个公司使用自己的身份连接区块链网络
+	// Every company connects to the Blockchain Network with its own identity
+	ledger = NewLedgerClient(wallet, filepath.Clean(ccpPath))
+	defer ledger.Close()
+
+	// 以银行身份进行初始化
+	// Get init as the bank
+	contract, err := ledger.Contract(bankCompanyID)
 	if err != nil {
 		fmt.Printf("Failed to connect to gateway: %s\n", err)
 		os.Exit(1)
 	}
-	defer gw.Close()
-	// 连接到通道
-	// connect to the Blockchain Network
-	network, err := gw.GetNetwork("mychannel")
-	if err != nil {
-		fmt.Printf("Failed to get network: %s\n", err)
-		os.Exit(1)
-	}
-	// 获取链上代码（智能合约）名称
-	// Get smart contract name
-	contract = network.GetContract("fabcar")
-
-	// 进行初始化
-	// Get init
 	result, err := contract.SubmitTransaction("initLedger")
 	if err != nil {
 		fmt.Printf("Failed to submit transaction: %s\n", err)
@@ -116,14 +478,34 @@ func main() {
 	router.Use(Cors()) //开启中间件 允许使用跨域请求
 	// 定义路由
 	// Routers for admin / bank's / company's functions
-	A1 := router.Group("/A1/admin")
+	// 登录 / 退出 / 刷新令牌
+	// Login, logout and refresh the session token
+	auth := router.Group("/auth")
+	{
+		auth.POST("/login", login)
+		auth.POST("/logout", logout)
+		auth.POST("/refresh", refresh)
+	}
+	// 各路由组需要登录，并按角色控制访问
+	// Every route group requires login and is restricted by role
+	A1 := router.Group("/A1/admin", Authenticate(), RequireRole(Role_Admin))
 	{
-		// admin test funtion
-		A1.POST("/admintest", admintest)
 		// Query all the sign info function
 		A1.POST("/queryAllSignInfos", queryAllSignInfos)
+		// 重建票据索引  Rebuild the bill indexes
+		A1.POST("/rebuildBillIndexes", rebuildBillIndexes)
+		// 更正被标记的旧票据金额  Correct the amount of a flagged legacy bill
+		A1.POST("/correctBillAmount", correctBillAmount)
+		// 在账本上登记公司  Register a company on the ledger
+		A1.POST("/registerCompany", registerCompany)
+		// 查看所有票据  Search all the bill infos
+		A1.POST("/queryAllBills", queryAllBills)
+		// 更新所有票据的到期状态  Update the maturity state of all the bills
+		A1.POST("/refreshAllMaturity", refreshAllMaturity)
+		// 更新一张票据的到期状态  Update the maturity state of one bill
+		A1.POST("/refreshMaturity", refreshMaturity)
 	}
-	B1 := router.Group("/B1/bank")
+	B1 := router.Group("/B1/bank", Authenticate(), RequireRole(Role_Bank))
 	{
 		// issue bill function
 		// 发布票据
@@ -150,8 +532,26 @@ func main() {
 
 		// 查询历史记录	  Search a bill's operation history
 		B1.POST("/queryHistoryById", queryHistoryById)
+
+		// 更新票据到期状态  Update the maturity state of a bill / of all the bills
+		B1.POST("/refreshMaturity", refreshMaturity)
+		B1.POST("/refreshAllMaturity", refreshAllMaturity)
+
+		// 贴现后银行成为持票人，同样需要查看到期票据并提示付款
+		// After a discount the bank holds the bill, so it too checks its due bills and presents them
+		B1.POST("/checkDueBills", checkDueBills)
+		B1.POST("/presentBill", presentBill)
 	}
-	C1 := router.Group("/C1/company")
+	// 按交易ID查询交易回执，任何登录用户均可使用
+	// Look up a transaction receipt by ID, open to every logged in user
+	router.GET("/tx/:id", Authenticate(), queryTransaction)
+	// 按条件搜索票据，企业只能搜索自己作为当事人的票据
+	// Search the bills by filters, companies only find the bills they are a party of
+	router.POST("/bills/search", Authenticate(), searchBills)
+	// 查看一张票据的全部信息，包括背书及追索记录；企业只能查看自己作为当事人的票据
+	// Read one bill in full, with its endorsement chain and recourse claims. Companies only read the bills they are a party of
+	router.POST("/bills/detail", Authenticate(), billDetail)
+	C1 := router.Group("/C1/company", Authenticate(), RequireRole(Role_Company))
 	{
 		// 查看待承兑票据  Search all the bills which are waiting for paying
 		C1.POST("/checkWaitPayBills", checkWaitPayBills)
@@ -182,61 +582,450 @@ func main() {
 		// 拒绝背书操作	  Refuse to endorse
 		C1.POST("/disagreeEndorseBill", disagreeEndorseBill)
 
+		// 查看已到期及逾期的票据  Search the held bills which are matured or overdue
+		C1.POST("/checkDueBills", checkDueBills)
+		// 更新票据到期状态  Update the maturity state of a bill
+		C1.POST("/refreshMaturity", refreshMaturity)
+
+		// 提示付款  Present a due bill for payment
+		C1.POST("/presentBill", presentBill)
+		// 待付款票据  Search the bills presented to this company for payment
+		C1.POST("/checkWaitSettleBills", checkWaitSettleBills)
+		// 同意付款，票据结清  Pay a presented bill, it is settled
+		C1.POST("/agreePresentBill", agreePresentBill)
+		// 拒绝付款  Refuse to pay a presented bill
+		C1.POST("/disagreePresentBill", disagreePresentBill)
+
+	}
+	// 追索：持票人及被追索人可能是企业或银行
+	// Recourse: the holder and the liable party may be companies or banks
+	R1 := router.Group("/R1/recourse", Authenticate(), RequireRole(Role_Company, Role_Bank))
+	{
+		// 查看可追索当事人  Search the parties the holder can claim against
+		R1.POST("/queryLiableParties", queryLiableParties)
+		// 发起追索  Claim recourse against a liable party
+		R1.POST("/claimRecourse", claimRecourse)
+		// 待答复的追索  Search the claims made against this company
+		R1.POST("/checkWaitRecourseBills", checkWaitRecourseBills)
+		// 同意清偿  Agree to pay the holder
+		R1.POST("/agreeRecourse", agreeRecourse)
+		// 拒绝清偿  Refuse to pay the holder
+		R1.POST("/disagreeRecourse", disagreeRecourse)
+		// 确认收款，票据结清  Confirm the payment, the bill is settled
+		R1.POST("/settleRecourse", settleRecourse)
 	}
 	// listen port
 	router.Run(":8000")
 }
 
-//——————————————————————————————管理员———————admin———————————————————————————————————————
-// 登陆处理 admin test funtion
-func admintest(ctx *gin.Context) {
+//——————————————————————————————登录————————auth——————————————————————————————————————————
+// 登录，校验用户名密码并签发会话令牌
+// Login, check the username and password and issue a session token
+func login(ctx *gin.Context) {
 	// 绑定传来的form
 	// Receive the from and get info from the front end
 	var signinfo SignInfo
-	err := ctx.ShouldBind(&signinfo)
+	if !bindRequest(ctx, &signinfo) {
+		return
+	}
+	// 在链下用户存储中校验用户名和密码
+	// Check the username and password against the off-chain user store
+	record, err := users.Authenticate(signinfo.Username, signinfo.Password)
 	if err != nil {
-		fmt.Printf("绑定失败: %s\n", err)
-	} else {
-		fmt.Printf("绑定成功: %s\n", err)
+		abortWithError(ctx, http.StatusUnauthorized, "unauthenticated", err.Error())
+		return
 	}
+	session, err := sessions.Create(record)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	respond(ctx, session, nil)
+}
+
+// 退出登录，使令牌失效
+// Logout, revoke the session token
+func logout(ctx *gin.Context) {
+	sessions.Delete(bearerToken(ctx))
+	respond(ctx, nil, nil)
+}
 
-	// 调用智能合约中的QuerySignInfo方法，查询所有用户信息
-	// Call the QuerySignInfo function, query all the users' infos
-	results, err := contract.SubmitTransaction("querySignInfo")
+// 刷新令牌，签发新令牌并使旧令牌失效
+// Refresh, issue a new token and revoke the old one
+func refresh(ctx *gin.Context) {
+	session, err := sessions.Refresh(bearerToken(ctx))
 	if err != nil {
-		fmt.Printf("Failed to submit transaction: %s\n", err)
-		os.Exit(1)
+		abortWithError(ctx, http.StatusUnauthorized, "unauthenticated", err.Error())
+		return
+	}
+	respond(ctx, session, nil)
+}
+
+// 从请求头 Authorization: Bearer <token> 中读取令牌
+// Read the token from the "Authorization: Bearer <token>" header
+func bearerToken(ctx *gin.Context) string {
+	header := ctx.GetHeader("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+}
+
+// 统一的响应格式，成功和失败都使用此结构
+// The envelope of every response, both success and failure
+type Response struct {
+	Code    string      `json:"code"`              // 错误码，成功时为 "ok"  Error code, "ok" on success
+	Message string      `json:"message"`           // 提示信息  Message
+	Data    interface{} `json:"data"`              // 返回数据  Payload
+	TxID    string      `json:"txId,omitempty"`    // 写操作的交易ID  Transaction ID of a write
+	Receipt *Receipt    `json:"receipt,omitempty"` // 写操作的交易回执  Receipt of a write
+}
+
+// 交易回执，记录交易ID、验证状态、所在区块及时间，用于证明操作已上链
+// The receipt of a transaction: its ID, validation status, block and time, proving when it was committed
+type Receipt struct {
+	TxID        string `json:"txId"`                // 交易ID  Transaction ID
+	Status      string `json:"status"`              // 验证状态，例如 VALID  Validation code, e.g. VALID
+	BlockNumber uint64 `json:"blockNumber"`         // 区块号  Block number
+	Timestamp   string `json:"timestamp,omitempty"` // 交易时间（UTC，RFC3339）  Transaction timestamp in UTC RFC3339
+}
+
+// 链码及网络错误与HTTP状态码的对应关系，按顺序匹配错误信息
+// Maps chaincode and network errors to HTTP status codes, matched against the error message in order
+var ledgerErrorMappings = []struct {
+	pattern string
+	status  int
+	code    string
+}{
+	{"invalid search", http.StatusBadRequest, "bad_request"},
+	{"invalid argument", http.StatusBadRequest, "bad_request"},
+	{"does not exist", http.StatusNotFound, "not_found"},
+	{"is due since", http.StatusConflict, "illegal_state"},
+	{"is not transferable", http.StatusConflict, "illegal_state"},
+	{"not found in index", http.StatusNotFound, "not_found"},
+	{"illegal state transition", http.StatusConflict, "illegal_state"},
+	{"unauthorized", http.StatusForbidden, "unauthorized"},
+	{"MVCC_READ_CONFLICT", http.StatusConflict, "mvcc_conflict"},
+	{"PHANTOM_READ_CONFLICT", http.StatusConflict, "mvcc_conflict"},
+	{"deadline exceeded", http.StatusGatewayTimeout, "timeout"},
+	{"didn't receive block event", http.StatusGatewayTimeout, "timeout"},
+	{"Timeout", http.StatusGatewayTimeout, "timeout"},
+	{"timeout", http.StatusGatewayTimeout, "timeout"},
+}
+
+// 返回错误响应并终止请求
+// Abort the request with an error body
+func abortWithError(ctx *gin.Context, status int, code string, message string) {
+	ctx.AbortWithStatusJSON(status, Response{Code: code, Message: message})
+}
+
+// 返回成功响应
+// Write a successful response
+func respond(ctx *gin.Context, data interface{}, receipt *Receipt) {
+	response := Response{Code: "ok", Message: "success", Data: data, Receipt: receipt}
+	if receipt != nil {
+		response.TxID = receipt.TxID
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// 将链码返回的票据数组解码后返回
+// Decode the bills returned by the chaincode and write them to the response
+func respondBills(ctx *gin.Context, results []byte) {
+	var bills []Bill
+	if len(results) > 0 {
+		err := json.Unmarshal(results, &bills)
+		if err != nil {
+			abortWithError(ctx, http.StatusBadGateway, "ledger_error", err.Error())
+			return
+		}
+	}
+	if bills == nil {
+		bills = []Bill{}
+	}
+	respond(ctx, bills, nil)
+}
+
+// 查询票据列表：带 pageSize 参数时调用链码的分页版本并返回书签，否则返回全部结果
+// Query a list of bills: with a pageSize the paginated chaincode function is called and
+// the bookmark returned, otherwise all the results are
+func queryBills(ctx *gin.Context, name string, args ...string) {
+	var page PageRequest
+	if !bindQuery(ctx, &page) {
+		return
+	}
+	if page.PageSize == 0 {
+		results, err := evaluateTransaction(ctx, name, args...)
+		if err != nil {
+			fmt.Printf("Failed to evaluate transaction: %s\n", err)
+			abortWithLedgerError(ctx, err)
+			return
+		}
+		respondBills(ctx, results)
+		return
+	}
+	args = append(args, strconv.Itoa(page.PageSize), page.Bookmark)
+	results, err := evaluateTransaction(ctx, name+"WithPagination", args...)
+	if err != nil {
+		fmt.Printf("Failed to evaluate transaction: %s\n", err)
+		abortWithLedgerError(ctx, err)
+		return
+	}
+	respondBillPage(ctx, results)
+}
+
+// 将链码返回的一页票据解码后返回
+// Decode the page of bills returned by the chaincode and write it to the response
+func respondBillPage(ctx *gin.Context, results []byte) {
+	var bills BillPage
+	err := json.Unmarshal(results, &bills)
+	if err != nil {
+		abortWithError(ctx, http.StatusBadGateway, "ledger_error", err.Error())
+		return
+	}
+	if bills.Bills == nil {
+		bills.Bills = []Bill{}
+	}
+	bills.Totals = billTotals(bills.Bills)
+	respond(ctx, &bills, nil)
+}
+
+// 将链码返回的单张票据解码后返回
+// Decode the bill returned by the chaincode and write it to the response
+func respondBill(ctx *gin.Context, results []byte, receipt *Receipt) {
+	var bill Bill
+	err := json.Unmarshal(results, &bill)
+	if err != nil {
+		abortWithError(ctx, http.StatusBadGateway, "ledger_error", err.Error())
+		return
+	}
+	respond(ctx, &bill, receipt)
+}
+
+// 将区块链交易错误转换为对应的HTTP状态码返回，服务继续运行
+// Turn a failed transaction into the matching HTTP status, the server keeps running
+func abortWithLedgerError(ctx *gin.Context, err error) {
+	message := err.Error()
+	for _, mapping := range ledgerErrorMappings {
+		if strings.Contains(message, mapping.pattern) {
+			abortWithError(ctx, mapping.status, mapping.code, message)
+			return
+		}
+	}
+	abortWithError(ctx, http.StatusBadGateway, "ledger_error", message)
+}
+
+// 票据编号、公司ID及交易ID的格式
+// Formats of bill IDs, company IDs and transaction IDs
+var (
+	billIDPattern   = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+	partyIDPattern  = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+	amountPattern   = regexp.MustCompile(`^[0-9]+(\.[0-9]{1,2})?$`)
+	currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+	txIDPattern     = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+// 注册自定义校验规则
+// Register the custom validation rules
+func registerValidators() error {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return errors.New("unexpected validator engine")
+	}
+	err := v.RegisterValidation("billid", func(fl validator.FieldLevel) bool {
+		return billIDPattern.MatchString(fl.Field().String())
+	})
+	if err != nil {
+		return err
+	}
+	err = v.RegisterValidation("partyid", func(fl validator.FieldLevel) bool {
+		return partyIDPattern.MatchString(fl.Field().String())
+	})
+	if err != nil {
+		return err
+	}
+	// 金额为正数，最多两位小数
+	// Amounts are positive with at most two decimals
+	err = v.RegisterValidation("amount", func(fl validator.FieldLevel) bool {
+		fen, err := parseFen(fl.Field().String())
+		return err == nil && fen > 0
+	})
+	if err != nil {
+		return err
+	}
+	err = v.RegisterValidation("currency", func(fl validator.FieldLevel) bool {
+		return currencyPattern.MatchString(fl.Field().String())
+	})
+	if err != nil {
+		return err
+	}
+	v.RegisterStructValidation(validateBillDates, Bill{})
+	return nil
+}
+
+// 到期日期必须晚于出票日期
+// The due date must be after the issue date
+func validateBillDates(sl validator.StructLevel) {
+	bill := sl.Current().Interface().(Bill)
+	issueDate, err := time.Parse("2006-01-02", bill.BillInfoIssueDate)
+	if err != nil {
+		return
+	}
+	dueDate, err := time.Parse("2006-01-02", bill.BillInfoDueDate)
+	if err != nil {
+		return
+	}
+	if !dueDate.After(issueDate) {
+		sl.ReportError(bill.BillInfoDueDate, "BillInfoDueDate", "BillInfoDueDate", "afterissuedate", "")
+	}
+	// 票据期限不超过最长期限，与链码一致
+	// The term is within the longest term, the same as the chaincode's
+	if dueDate.After(issueDate.AddDate(0, maxBillTermMonths, 0)) {
+		sl.ReportError(bill.BillInfoDueDate, "BillInfoDueDate", "BillInfoDueDate", "maxterm", strconv.Itoa(maxBillTermMonths))
+	}
+}
+
+// 绑定并校验请求，失败时返回400及出错的字段，此时处理函数应直接返回
+// Bind and validate the request. On failure a 400 with the failed fields is written and the handler must return
+func bindRequest(ctx *gin.Context, obj interface{}) bool {
+	return checkBinding(ctx, ctx.ShouldBind(obj))
+}
+
+// 绑定URL查询参数并校验，失败时返回400
+// Bind the URL query and validate it, a failure is answered with 400
+func bindQuery(ctx *gin.Context, obj interface{}) bool {
+	return checkBinding(ctx, ctx.ShouldBindQuery(obj))
+}
+
+// 将绑定或校验错误返回给前端，成功时返回 true
+// Report a binding or validation error to the front end, true when there is none
+func checkBinding(ctx *gin.Context, err error) bool {
+	if err == nil {
+		return true
+	}
+	validationErrors, ok := err.(validator.ValidationErrors)
+	if !ok {
+		abortWithError(ctx, http.StatusBadRequest, "bad_request", err.Error())
+		return false
+	}
+	fields := make([]FieldError, 0, len(validationErrors))
+	for _, fieldError := range validationErrors {
+		fields = append(fields, FieldError{Field: fieldError.Field(), Rule: fieldError.Tag(), Param: fieldError.Param()})
 	}
+	ctx.AbortWithStatusJSON(http.StatusBadRequest, Response{Code: "bad_request", Message: "invalid request", Data: fields})
+	return false
+}
+
+// 登录校验中间件，将会话存入上下文供后续处理使用
+// Authentication middleware, stores the caller's session in the context for the handlers
+func Authenticate() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		session, ok := sessions.Get(bearerToken(ctx))
+		if !ok {
+			abortWithError(ctx, http.StatusUnauthorized, "unauthenticated", "login required")
+			return
+		}
+		ctx.Set(principalKey, session)
+		ctx.Next()
+	}
+}
 
-	// Deal with the receive infos
-	// 声明SignInfo类型结构体数组
-	var jsonresults []SignInfo
-	// 将返回数据序列化，存入上述结构体数组 deserialize
-	json.Unmarshal(results, &jsonresults)
-
-	// Check the admin infos
-	// 遍历查询结果,如果用户名密码符合，则返回其signinfo，否则返回失败
-	for i := range jsonresults {
-		// 如果数据符合条件，返回此signinfo
-		if jsonresults[i].Username == signinfo.Username && jsonresults[i].Password == signinfo.Password {
-			fmt.Println(jsonresults[i])
-			data, _ := json.Marshal(jsonresults[i])
-			ctx.JSON(http.StatusOK, string(data))
+// 角色控制中间件，可用于路由组或单个路由，需在 Authenticate 之后使用
+// Role middleware for a route group or a single route, must be used after Authenticate
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		session := principal(ctx)
+		for _, role := range roles {
+			if session != nil && session.Role == role {
+				ctx.Next()
+				return
+			}
 		}
+		abortWithError(ctx, http.StatusForbidden, "forbidden", "permission denied")
+	}
+}
+
+// 获取当前请求的登录用户
+// The logged in user of the request
+func principal(ctx *gin.Context) *Session {
+	value, ok := ctx.Get(principalKey)
+	if !ok {
+		return nil
 	}
+	return value.(*Session)
 }
 
+//——————————————————————————————管理员———————admin———————————————————————————————————————
 // 查询所有signinfo   Query all the sign info function
+// 只返回用户名、公司和角色，不返回密码
+// Only usernames, companies and roles are returned, never the passwords
 func queryAllSignInfos(ctx *gin.Context) {
-	// 进行交易
-	results, err := contract.SubmitTransaction("querySignInfo")
+	respond(ctx, users.List(), nil)
+}
+
+// 重建票据索引，升级链码后对已有票据执行一次；返回金额无法识别、需要更正的票据编号
+// Rebuild the bill indexes, run once after upgrading the chaincode over existing bills. The IDs of
+// the bills whose amount can't be parsed and needs correcting are returned
+func rebuildBillIndexes(ctx *gin.Context) {
+	results, receipt, err := submitTransaction(ctx, "rebuildBillIndexes")
 	if err != nil {
 		fmt.Printf("Failed to submit transaction: %s\n", err)
-		os.Exit(1)
+		abortWithLedgerError(ctx, err)
+		return
+	}
+	flagged := []string{}
+	if len(results) > 0 {
+		err = json.Unmarshal(results, &flagged)
+		if err != nil {
+			abortWithError(ctx, http.StatusBadGateway, "ledger_error", err.Error())
+			return
+		}
 	}
-	fmt.Println(string(results))
+	respond(ctx, flagged, receipt)
+}
+
+// 更正被标记的旧票据金额
+// Correct the amount of a flagged legacy bill
+func correctBillAmount(ctx *gin.Context) {
+	var request CorrectBillAmountRequest
+	if !bindRequest(ctx, &request) {
+		return
+	}
+	if request.BillInfoCurrency == "" {
+		request.BillInfoCurrency = defaultCurrency
+	}
+	results, receipt, err := submitTransaction(ctx, "correctBillAmount", request.BillInfoID, request.BillInfoMoney, request.BillInfoCurrency)
+	if err != nil {
+		fmt.Printf("Failed to submit transaction: %s\n", err)
+		abortWithLedgerError(ctx, err)
+		return
+	}
+	respondBill(ctx, results, receipt)
+}
 
-	ctx.JSON(http.StatusOK, string(results))
+// 在账本上登记公司，之后以该公司ID及MSP签发的证书才能调用链码
+// Register a company on the ledger, certificates of that company ID and MSP are honoured afterwards
+func registerCompany(ctx *gin.Context) {
+	var request RegisterCompanyRequest
+	if !bindRequest(ctx, &request) {
+		return
+	}
+	if request.MSPID == "" {
+		request.MSPID = defaultMSPID
+	}
+	results, receipt, err := submitTransaction(ctx, "registerCompany", request.CompanyId, request.CompanyName, request.Role, request.MSPID)
+	if err != nil {
+		fmt.Printf("Failed to submit transaction: %s\n", err)
+		abortWithLedgerError(ctx, err)
+		return
+	}
+	var company Company
+	err = json.Unmarshal(results, &company)
+	if err != nil {
+		abortWithError(ctx, http.StatusBadGateway, "ledger_error", err.Error())
+		return
+	}
+	respond(ctx, &company, receipt)
 }
 
 //——————————————————————————————银行——————bank——————————————————————————————————————————
@@ -245,92 +1034,55 @@ func issueBill(ctx *gin.Context) {
 	// 绑定传来的form
 	// receive the bill's info from front-end
 	var bill Bill
-	err := ctx.ShouldBind(&bill)
-	if err != nil {
-		fmt.Printf("绑定失败: %s\n", err)
-	} else {
-		fmt.Printf("绑定成功: %s\n", err)
+	if !bindRequest(ctx, &bill) {
+		return
 	}
 	// Call issueBill smart contract to create the new bill
 	// 调用智能合约中的issueBill方法，并传递票据信息
-	results, err := contract.SubmitTransaction("issueBill", bill.BillInfoID, bill.BillInfoMoney, bill.BillInfoType, bill.BillInfoIssueDate, bill.BillInfoDueDate, bill.PubBillID, bill.PubBillName, bill.PayBillID, bill.PayBillName, bill.AcceptBillID, bill.AcceptBillName, bill.HoldBillID, bill.HoldBillName)
+	if bill.BillInfoCurrency == "" {
+		bill.BillInfoCurrency = defaultCurrency
+	}
+	results, receipt, err := submitTransaction(ctx, "issueBill", bill.BillInfoID, bill.BillInfoMoney, bill.BillInfoCurrency, bill.BillInfoType, bill.BillInfoIssueDate, bill.BillInfoDueDate, bill.PubBillID, bill.PubBillName, bill.PayBillID, bill.PayBillName, bill.AcceptBillID, bill.AcceptBillName, bill.HoldBillID, bill.HoldBillName, strconv.FormatBool(bill.NonTransferable))
 	if err != nil {
 		fmt.Printf("Failed to submit transaction: %s\n", err)
-		os.Exit(1)
+		abortWithLedgerError(ctx, err)
+		return
 	}
-	fmt.Println(string(results))
+	respondBill(ctx, results, receipt)
 }
 
 // 查看所有票据 Query all the bill infos
 func queryAllBills(ctx *gin.Context) {
 	// Call queryAllBill smart contract to query all the bill infos
 	// 向区块链发送交易请求，调用智能合约中的queryAllBill方法，查询票据信息
-	results, err := contract.SubmitTransaction("queryAllBill")
-	if err != nil {
-		fmt.Printf("Failed to submit transaction: %s\n", err)
-		os.Exit(1)
-	}
-	fmt.Println(string(results))
-	// 将查询结果以字符串形式发回前端
-	ctx.JSON(http.StatusOK, string(results))
+	queryBills(ctx, "queryAllBill")
 }
 
 // 更换承兑人（参加承兑） change the pay user
 func changePayBillInfo(ctx *gin.Context) {
-	// Search the bill info by id
-	//首先根据id查询票据
-	// 绑定传来的form
-	var getbill Bill
-	err := ctx.ShouldBind(&getbill)
-	if err != nil {
-		fmt.Printf("绑定失败: %s\n", err)
-	} else {
-		fmt.Printf("绑定成功: %s\n", err)
+	// 绑定传来的form，获取票据编号和新的承兑人信息
+	// Receive the bill's id and the new pay user info
+	var getbill ChangePayRequest
+	if !bindRequest(ctx, &getbill) {
+		return
 	}
-	// 查询此id的票据的信息
-	results, err := contract.SubmitTransaction("QueryBillById", getbill.BillInfoID)
+	// 调用智能合约中的changeAccept方法，由链码读取票据并修改承兑人
+	// Call changeAccept smart contract, the chaincode loads the bill and changes the pay user
+	results, receipt, err := submitTransaction(ctx, "changeAccept", getbill.BillInfoID, getbill.PayBillID, getbill.PayBillName)
 	if err != nil {
 		fmt.Printf("Failed to submit transaction: %s\n", err)
-		os.Exit(1)
-	}
-	fmt.Println(string(results))
-	// Update the bill's infos, change the bill's pay person info
-	// 将bill转为struct格式,以便对修改承兑人
-	var bill Bill
-	json.Unmarshal(results, &bill)
-	fmt.Println(bill)
-	bill.PayBillID = getbill.PayBillID
-	bill.PayBillName = getbill.PayBillName
-	// 将修改好承兑人信息的数据存入区块链
-	// Call issueBill smart contract to update, it will cover the bill's info with the same bill id
-	results, err = contract.SubmitTransaction("issueBill", bill.BillInfoID, bill.BillInfoMoney, bill.BillInfoType, bill.BillInfoIssueDate, bill.BillInfoDueDate, bill.PubBillID, bill.PubBillName, bill.PayBillID, bill.PayBillName, bill.AcceptBillID, bill.AcceptBillName, bill.HoldBillID, bill.HoldBillName)
-	if err != nil {
-		fmt.Printf("Failed to submit transaction: %s\n", err)
-		os.Exit(1)
+		abortWithLedgerError(ctx, err)
+		return
 	}
-	fmt.Println(string(results))
+	respondBill(ctx, results, receipt)
 }
 
 // 查询待贴现票据  Query all the bills which are waiting for discount
 // -查询
 func queryWaitDiscountBills(ctx *gin.Context) {
-	// 绑定传来的form
-	var getbill Bill
-	err := ctx.ShouldBind(&getbill)
-	if err != nil {
-		fmt.Printf("绑定失败: %s\n", err)
-	} else {
-		fmt.Printf("绑定成功: %s\n", err)
-	}
 	// 调用智能合约方法queryWaitDiscountBills，查询所有待贴现票据
 	// Call queryWaitDiscountBills() smart contract to query
-	results, err := contract.SubmitTransaction("queryWaitDiscountBills")
-	if err != nil {
-		fmt.Printf("Failed to submit transaction: %s\n", err)
-		os.Exit(1)
-	}
-	fmt.Println(string(results))
-	ctx.JSON(http.StatusOK, string(results))
+	queryBills(ctx, "queryWaitDiscountBills")
 }
 
 // -处理
@@ -341,21 +1093,19 @@ func queryWaitDiscountBills(ctx *gin.Context) {
 // 3. Change the bill's pay user info
 func agreeDiscountBills(ctx *gin.Context) {
 	// 绑定传来的form
-	var bill Bill
-	err := ctx.ShouldBind(&bill)
-	if err != nil {
-		fmt.Printf("绑定失败: %s\n", err)
-	} else {
-		fmt.Printf("绑定成功: %s\n", err)
+	var bill BillIDRequest
+	if !bindRequest(ctx, &bill) {
+		return
 	}
-	// 调用智能合约方法agreeDiscountBill，并将新的票据信息传入方法中
-	// Call agreeDiscountBill() smart contract to update bill info
-	results, err := contract.SubmitTransaction("agreeDiscountBill", bill.BillInfoID, bill.BillInfoMoney, bill.BillInfoType, bill.BillInfoIssueDate, bill.BillInfoDueDate, bill.PubBillID, bill.PubBillName, bill.PayBillID, bill.PayBillName, bill.AcceptBillID, bill.AcceptBillName, bill.HoldBillID, bill.HoldBillName)
+	// 调用智能合约方法agreeDiscountBill，并传递票据编号
+	// Call agreeDiscountBill() smart contract with the bill's id, the chaincode reads the rest from the ledger
+	results, receipt, err := submitTransaction(ctx, "agreeDiscountBill", bill.BillInfoID)
 	if err != nil {
 		fmt.Printf("Failed to submit transaction: %s\n", err)
-		os.Exit(1)
+		abortWithLedgerError(ctx, err)
+		return
 	}
-	fmt.Println(string(results))
+	respondBill(ctx, results, receipt)
 }
 
 // -拒绝贴现：更改状态为Public且更改Message为DiscountFail
@@ -364,20 +1114,18 @@ func agreeDiscountBills(ctx *gin.Context) {
 // 2. Change the bill message to DiscountFail
 func aDisagreeDiscountBills(ctx *gin.Context) {
 	// 绑定传来的form
-	var bill Bill
-	err := ctx.ShouldBind(&bill)
-	if err != nil {
-		fmt.Printf("绑定失败: %s\n", err)
-	} else {
-		fmt.Printf("绑定成功: %s\n", err)
+	var bill BillIDRequest
+	if !bindRequest(ctx, &bill) {
+		return
 	}
 	// 进行交易
-	results, err := contract.SubmitTransaction("aDisagreeDiscountBill", bill.BillInfoID, bill.BillInfoMoney, bill.BillInfoType, bill.BillInfoIssueDate, bill.BillInfoDueDate, bill.PubBillID, bill.PubBillName, bill.PayBillID, bill.PayBillName, bill.AcceptBillID, bill.AcceptBillName, bill.HoldBillID, bill.HoldBillName)
+	results, receipt, err := submitTransaction(ctx, "aDisagreeDiscountBill", bill.BillInfoID)
 	if err != nil {
 		fmt.Printf("Failed to submit transaction: %s\n", err)
-		os.Exit(1)
+		abortWithLedgerError(ctx, err)
+		return
 	}
-	fmt.Println(string(results))
+	respondBill(ctx, results, receipt)
 }
 
 // Query the bill's operation history
@@ -385,22 +1133,131 @@ func aDisagreeDiscountBills(ctx *gin.Context) {
 func queryHistoryById(ctx *gin.Context) {
 	// Query by bill's id, receive the id from front-end
 	// 接收票据编号信息
-	var getbill Bill
-	err := ctx.ShouldBind(&getbill)
-	if err != nil {
-		fmt.Printf("绑定失败: %s\n", err)
-	} else {
-		fmt.Printf("绑定成功: %s\n", err)
+	var getbill BillIDRequest
+	if !bindRequest(ctx, &getbill) {
+		return
 	}
 	// 调用智能合约queryHistoryById方法，并传递票据编号以查询
 	// Call queryHistoryById smart contract to query
-	results, err := contract.SubmitTransaction("queryHistoryById", getbill.BillInfoID)
+	results, err := evaluateTransaction(ctx, "queryHistoryById", getbill.BillInfoID)
+	if err != nil {
+		fmt.Printf("Failed to evaluate transaction: %s\n", err)
+		abortWithLedgerError(ctx, err)
+		return
+	}
+	// 历史记录按交易先后排列，每条包含交易ID、时间、调用者及当时的票据
+	// The entries are in commit order, each with the txID, time, invoker and the bill as written
+	history := []HistoryEntry{}
+	if len(results) > 0 {
+		err = json.Unmarshal(results, &history)
+		if err != nil {
+			abortWithError(ctx, http.StatusBadGateway, "ledger_error", err.Error())
+			return
+		}
+	}
+	respond(ctx, history, nil)
+}
+
+// 按条件搜索票据，分页返回
+// Search the bills by filters, one page at a time
+func searchBills(ctx *gin.Context) {
+	var search SearchRequest
+	if !bindRequest(ctx, &search) {
+		return
+	}
+	// 银行和管理员以外只能搜索自己作为当事人的票据，以登录会话中的公司ID为准
+	// Anyone but banks and admins only searches the bills it is a party of, the company comes from the login session
+	session := principal(ctx)
+	if session.Role != Role_Bank && session.Role != Role_Admin {
+		search.PartyID = session.CompanyId
+	}
+	if search.PageSize == 0 {
+		search.PageSize = defaultPageSize
+	}
+	filter, err := json.Marshal(search.BillSearch)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	results, err := evaluateTransaction(ctx, "searchBills", string(filter), strconv.Itoa(search.PageSize), search.Bookmark)
+	if err != nil {
+		fmt.Printf("Failed to evaluate transaction: %s\n", err)
+		abortWithLedgerError(ctx, err)
+		return
+	}
+	respondBillPage(ctx, results)
+}
+
+// 查看一张票据的全部信息，由链码按调用者的角色及当事人身份授权
+// Read one bill in full, the chaincode authorizes it by the caller's role and parties
+func billDetail(ctx *gin.Context) {
+	var bill BillIDRequest
+	if !bindRequest(ctx, &bill) {
+		return
+	}
+	results, err := evaluateTransaction(ctx, "queryBillById", bill.BillInfoID)
+	if err != nil {
+		fmt.Printf("Failed to evaluate transaction: %s\n", err)
+		abortWithLedgerError(ctx, err)
+		return
+	}
+	respondBill(ctx, results, nil)
+}
+
+// 按交易ID查询交易是否上链及其区块号和时间
+// Look up whether a transaction was committed, with its block number and time
+func queryTransaction(ctx *gin.Context) {
+	txID := ctx.Param("id")
+	if !txIDPattern.MatchString(txID) {
+		abortWithError(ctx, http.StatusBadRequest, "bad_request", "invalid transaction id")
+		return
+	}
+	receipt, err := lookupTransaction(ctx, txID)
+	if err != nil {
+		fmt.Printf("Failed to look up transaction: %s\n", err)
+		abortWithLedgerError(ctx, err)
+		return
+	}
+	respond(ctx, receipt, nil)
+}
+
+// 按交易时间更新一张票据的到期状态
+// Update the maturity state of one bill by the transaction time
+func refreshMaturity(ctx *gin.Context) {
+	var bill BillIDRequest
+	if !bindRequest(ctx, &bill) {
+		return
+	}
+	results, receipt, err := submitTransaction(ctx, "refreshMaturity", bill.BillInfoID)
 	if err != nil {
 		fmt.Printf("Failed to submit transaction: %s\n", err)
-		os.Exit(1)
+		abortWithLedgerError(ctx, err)
+		return
+	}
+	respondBill(ctx, results, receipt)
+}
+
+// 更新所有票据的到期状态，返回状态发生变化的票据
+// Update the maturity state of every bill, the bills whose state changed are returned
+func refreshAllMaturity(ctx *gin.Context) {
+	results, receipt, err := submitTransaction(ctx, "refreshAllMaturity")
+	if err != nil {
+		fmt.Printf("Failed to submit transaction: %s\n", err)
+		abortWithLedgerError(ctx, err)
+		return
 	}
-	fmt.Println(string(results))
-	ctx.JSON(http.StatusOK, string(results))
+	var bills []Bill
+	if len(results) > 0 {
+		err = json.Unmarshal(results, &bills)
+		if err != nil {
+			abortWithError(ctx, http.StatusBadGateway, "ledger_error", err.Error())
+			return
+		}
+	}
+	if bills == nil {
+		bills = []Bill{}
+	}
+	respond(ctx, bills, receipt)
 }
 
 //——————————————————————————————企业————————company————————————————————————————————————————
@@ -408,65 +1265,49 @@ func queryHistoryById(ctx *gin.Context) {
 // 查看待承兑票据 - 需要查询 PayBillID 为个人 和 State 为 Made 的数据
 // Query all the bill's which are waiting for the company to pay
 func checkWaitPayBills(ctx *gin.Context) {
-	// Receive company's ID
-	//接收传来的公司ID
-	var getbill Bill
-	err := ctx.ShouldBind(&getbill)
-	if err != nil {
-		fmt.Printf("绑定失败: %s\n", err)
-	} else {
-		fmt.Printf("绑定成功: %s\n", err)
-	}
+	// 从登录会话中获取调用者的公司ID，不再信任请求中传来的ID
+	// Take the caller's company ID from the login session instead of trusting the request
+	companyID := principal(ctx).CompanyId
 	// 调用智能合约queryWaitPayBills，传递公司ID参数。
 	// Query by calling queryWaitPayBills() smart contract, according to company's ID and bill state 'Made'
-	results, err := contract.SubmitTransaction("queryWaitPayBills", getbill.PayBillID)
-	if err != nil {
-		fmt.Printf("Failed to submit transaction: %s\n", err)
-		os.Exit(1)
-	}
-	fmt.Println(string(results))
-	ctx.JSON(http.StatusOK, string(results))
+	queryBills(ctx, "queryWaitPayBills", companyID)
 }
 
 // 同意承兑 - 将State变为public
 // Agree to pay, change the bill state to public
 func agreePay(ctx *gin.Context) {
 	// 绑定传来的form
-	var bill Bill
-	err := ctx.ShouldBind(&bill)
-	if err != nil {
-		fmt.Printf("绑定失败: %s\n", err)
-	} else {
-		fmt.Printf("绑定成功: %s\n", err)
+	var bill BillIDRequest
+	if !bindRequest(ctx, &bill) {
+		return
 	}
 	// 调用智能合约方法agreePayBill，对票据的状态进行修改
 	// Call agreePayBill() smart contract to update bill info
-	results, err := contract.SubmitTransaction("agreePayBill", bill.BillInfoID, bill.BillInfoMoney, bill.BillInfoType, bill.BillInfoIssueDate, bill.BillInfoDueDate, bill.PubBillID, bill.PubBillName, bill.PayBillID, bill.PayBillName, bill.AcceptBillID, bill.AcceptBillName, bill.HoldBillID, bill.HoldBillName)
+	results, receipt, err := submitTransaction(ctx, "agreePayBill", bill.BillInfoID)
 	if err != nil {
 		fmt.Printf("Failed to submit transaction: %s\n", err)
-		os.Exit(1)
+		abortWithLedgerError(ctx, err)
+		return
 	}
-	fmt.Println(string(results))
+	respondBill(ctx, results, receipt)
 }
 
 // 拒绝承兑 - 将所有信息抹去，State变为“BillFail”
 // Refuse to pay, delete bill's info except the ID
 func disagreePay(ctx *gin.Context) {
 	// 绑定传来的form
-	var bill Bill
-	err := ctx.ShouldBind(&bill)
-	if err != nil {
-		fmt.Printf("绑定失败: %s\n", err)
-	} else {
-		fmt.Printf("绑定成功: %s\n", err)
+	var bill BillIDRequest
+	if !bindRequest(ctx, &bill) {
+		return
 	}
 	// 进行交易
-	results, err := contract.SubmitTransaction("disagreePayBill", bill.BillInfoID)
+	results, receipt, err := submitTransaction(ctx, "disagreePayBill", bill.BillInfoID)
 	if err != nil {
 		fmt.Printf("Failed to submit transaction: %s\n", err)
-		os.Exit(1)
+		abortWithLedgerError(ctx, err)
+		return
 	}
-	fmt.Println(string(results))
+	respondBill(ctx, results, receipt)
 }
 
 // Query all the bills related to this user
@@ -474,70 +1315,28 @@ func disagreePay(ctx *gin.Context) {
 // Query bills which need to pay and state is 'Public'
 // -查看pay身份的bill且state为Public的
 func checkAllPayBills(ctx *gin.Context) {
-	//首先根据id查询票据
-	// 绑定传来的form
-	var getbill Bill
-	err := ctx.ShouldBind(&getbill)
-	if err != nil {
-		fmt.Printf("绑定失败: %s\n", err)
-	} else {
-		fmt.Printf("绑定成功: %s\n", err)
-	}
+	// 登录用户的公司ID  Company ID of the logged in user
+	companyID := principal(ctx).CompanyId
 	// 进行交易
-	results, err := contract.SubmitTransaction("queryAllPayBills", getbill.PayBillID)
-	if err != nil {
-		fmt.Printf("Failed to submit transaction: %s\n", err)
-		os.Exit(1)
-	}
-	fmt.Println(string(results))
-
-	ctx.JSON(http.StatusOK, string(results))
+	queryBills(ctx, "queryAllPayBills", companyID)
 }
 
 // -查看accept身份的bill且state为Public的
 // Query bills which need to accept and state is 'Public'
 func checkAllAcceptBills(ctx *gin.Context) {
-	//首先根据id查询票据
-	// 绑定传来的form
-	var getbill Bill
-	err := ctx.ShouldBind(&getbill)
-	if err != nil {
-		fmt.Printf("绑定失败: %s\n", err)
-	} else {
-		fmt.Printf("绑定成功: %s\n", err)
-	}
+	// 登录用户的公司ID  Company ID of the logged in user
+	companyID := principal(ctx).CompanyId
 	// 进行交易
-	results, err := contract.SubmitTransaction("queryAllAcceptBills", getbill.AcceptBillID)
-	if err != nil {
-		fmt.Printf("Failed to submit transaction: %s\n", err)
-		os.Exit(1)
-	}
-	fmt.Println(string(results))
-
-	ctx.JSON(http.StatusOK, string(results))
+	queryBills(ctx, "queryAllAcceptBills", companyID)
 }
 
 // -查看hold身份的bill且state为Public的
 // Query bills which need to hold and state is 'Public'
 func checkAllHoldBills(ctx *gin.Context) {
-	//首先根据id查询票据
-	// 绑定传来的form
-	var getbill Bill
-	err := ctx.ShouldBind(&getbill)
-	if err != nil {
-		fmt.Printf("绑定失败: %s\n", err)
-	} else {
-		fmt.Printf("绑定成功: %s\n", err)
-	}
+	// 登录用户的公司ID  Company ID of the logged in user
+	companyID := principal(ctx).CompanyId
 	// 进行交易
-	results, err := contract.SubmitTransaction("queryAllHoldBills", getbill.HoldBillID)
-	if err != nil {
-		fmt.Printf("Failed to submit transaction: %s\n", err)
-		os.Exit(1)
-	}
-	fmt.Println(string(results))
-
-	ctx.JSON(http.StatusOK, string(results))
+	queryBills(ctx, "queryAllHoldBills", companyID)
 }
 
 // 票据背书 --增加 EndorsedID、EndorsedName ，修改 State 为 EnWaitSign
@@ -545,119 +1344,630 @@ func checkAllHoldBills(ctx *gin.Context) {
 func endorseBill(ctx *gin.Context) {
 	// Get the EndorsedID、EndorsedName from front end
 	// 获取前端传递的票据被背书人的ID和名称
-	var bill Bill
-	err := ctx.ShouldBind(&bill)
-	if err != nil {
-		fmt.Printf("绑定失败: %s\n", err)
-	} else {
-		fmt.Printf("绑定成功: %s\n", err)
+	var bill EndorseRequest
+	if !bindRequest(ctx, &bill) {
+		return
 	}
-	// 调用智能合约方法endorseBill，并传递票据的被背书人信息
-	// Call endorseBill() smart contract to update
-	results, err := contract.SubmitTransaction("endorseBill", bill.BillInfoID, bill.BillInfoMoney, bill.BillInfoType, bill.BillInfoIssueDate, bill.BillInfoDueDate, bill.PubBillID, bill.PubBillName, bill.PayBillID, bill.PayBillName, bill.AcceptBillID, bill.AcceptBillName, bill.HoldBillID, bill.HoldBillName, bill.EndorsedID, bill.EndorsedName)
+	// 调用智能合约方法endorseBill，并传递票据编号和被背书人信息
+	// Call endorseBill() smart contract with the bill's id, the endorsee and whether the endorsement is restrictive
+	results, receipt, err := submitTransaction(ctx, "endorseBill", bill.BillInfoID, bill.EndorsedID, bill.EndorsedName, strconv.FormatBool(bill.Restrictive))
 	if err != nil {
 		fmt.Printf("Failed to submit transaction: %s\n", err)
-		os.Exit(1)
+		abortWithLedgerError(ctx, err)
+		return
 	}
-	fmt.Println(string(results))
+	respondBill(ctx, results, receipt)
 }
 
 // 同意票据背书  -- 更换承兑人
 // Agree to endorse, update the bill's pay user info
 func agreeEndorseBill(ctx *gin.Context) {
 	// 绑定传来的form
-	var bill Bill
-	err := ctx.ShouldBind(&bill)
-	if err != nil {
-		fmt.Printf("绑定失败: %s\n", err)
-	} else {
-		fmt.Printf("绑定成功: %s\n", err)
+	var bill BillIDRequest
+	if !bindRequest(ctx, &bill) {
+		return
 	}
 	// 进行交易
-	results, err := contract.SubmitTransaction("agreeEndorseBill", bill.BillInfoID, bill.BillInfoMoney, bill.BillInfoType, bill.BillInfoIssueDate, bill.BillInfoDueDate, bill.PubBillID, bill.PubBillName, bill.PayBillID, bill.PayBillName, bill.AcceptBillID, bill.AcceptBillName, bill.HoldBillID, bill.HoldBillName, bill.EndorsedID, bill.EndorsedName)
+	results, receipt, err := submitTransaction(ctx, "agreeEndorseBill", bill.BillInfoID)
 	if err != nil {
 		fmt.Printf("Failed to submit transaction: %s\n", err)
-		os.Exit(1)
+		abortWithLedgerError(ctx, err)
+		return
 	}
-	fmt.Println(string(results))
+	respondBill(ctx, results, receipt)
 }
 
 // 拒绝票据背书
 // Disagree to endorse
 func disagreeEndorseBill(ctx *gin.Context) {
 	// 绑定传来的form
-	var bill Bill
-	err := ctx.ShouldBind(&bill)
-	if err != nil {
-		fmt.Printf("绑定失败: %s\n", err)
-	} else {
-		fmt.Printf("绑定成功: %s\n", err)
+	var bill BillIDRequest
+	if !bindRequest(ctx, &bill) {
+		return
 	}
 	// 进行交易
-	results, err := contract.SubmitTransaction("disagreeEndorseBill", bill.BillInfoID, bill.BillInfoMoney, bill.BillInfoType, bill.BillInfoIssueDate, bill.BillInfoDueDate, bill.PubBillID, bill.PubBillName, bill.PayBillID, bill.PayBillName, bill.AcceptBillID, bill.AcceptBillName, bill.HoldBillID, bill.HoldBillName)
+	results, receipt, err := submitTransaction(ctx, "disagreeEndorseBill", bill.BillInfoID)
 	if err != nil {
 		fmt.Printf("Failed to submit transaction: %s\n", err)
-		os.Exit(1)
+		abortWithLedgerError(ctx, err)
+		return
 	}
-	fmt.Println(string(results))
+	respondBill(ctx, results, receipt)
 }
 
 // 待背书票据 -- 依据 EndorsedID 和 State 为 EnWaitSign 查询
 // Query bills which are waiting for endorsement, accoridng to bill's EndorsedID and state
 func checkWaitEndorseBills(ctx *gin.Context) {
-	// 绑定传来的form
-	var getbill Bill
-	err := ctx.ShouldBind(&getbill)
+	// 登录用户的公司ID  Company ID of the logged in user
+	companyID := principal(ctx).CompanyId
+	// 进行交易
+	queryBills(ctx, "queryWaitEndorseBills", companyID)
+}
+
+// 提示付款 -- 持票人向承兑人提示付款
+// Present a due bill to the pay user for payment
+func presentBill(ctx *gin.Context) {
+	var bill BillIDRequest
+	if !bindRequest(ctx, &bill) {
+		return
+	}
+	results, receipt, err := submitTransaction(ctx, "presentBill", bill.BillInfoID)
 	if err != nil {
-		fmt.Printf("绑定失败: %s\n", err)
-	} else {
-		fmt.Printf("绑定成功: %s\n", err)
+		fmt.Printf("Failed to submit transaction: %s\n", err)
+		abortWithLedgerError(ctx, err)
+		return
 	}
-	// 进行交易
-	results, err := contract.SubmitTransaction("queryWaitEndorseBills", getbill.EndorsedID)
+	respondBill(ctx, results, receipt)
+}
+
+// 待付款票据 -- 已向自己提示付款的票据
+// Query the bills presented to the company for payment
+func checkWaitSettleBills(ctx *gin.Context) {
+	// 登录用户的公司ID  Company ID of the logged in user
+	companyID := principal(ctx).CompanyId
+	queryBills(ctx, "queryWaitSettleBills", companyID)
+}
+
+// 同意付款 -- 承兑人付款，票据结清
+// Pay a presented bill, the bill is settled
+func agreePresentBill(ctx *gin.Context) {
+	var bill BillIDRequest
+	if !bindRequest(ctx, &bill) {
+		return
+	}
+	results, receipt, err := submitTransaction(ctx, "agreePresentBill", bill.BillInfoID)
 	if err != nil {
 		fmt.Printf("Failed to submit transaction: %s\n", err)
-		os.Exit(1)
+		abortWithLedgerError(ctx, err)
+		return
+	}
+	respondBill(ctx, results, receipt)
+}
+
+// 拒绝付款 -- 承兑人拒绝付款并给出理由
+// Refuse to pay a presented bill, with the reason
+func disagreePresentBill(ctx *gin.Context) {
+	var request DishonorRequest
+	if !bindRequest(ctx, &request) {
+		return
 	}
-	fmt.Println(string(results))
+	results, receipt, err := submitTransaction(ctx, "disagreePresentBill", request.BillInfoID, request.Reason)
+	if err != nil {
+		fmt.Printf("Failed to submit transaction: %s\n", err)
+		abortWithLedgerError(ctx, err)
+		return
+	}
+	respondBill(ctx, results, receipt)
+}
 
-	ctx.JSON(http.StatusOK, string(results))
+// 查看自己持有的已到期及逾期票据
+// Query the bills the company holds which are matured or overdue
+func checkDueBills(ctx *gin.Context) {
+	// 登录用户的公司ID  Company ID of the logged in user
+	companyID := principal(ctx).CompanyId
+	queryBills(ctx, "queryDueBills", companyID)
 }
 
 // 贴现操作 - 将 State 改为 DcWaitSigned
 // Apply to discount, change the state to 'DcWaitSigned'
 func discountBill(ctx *gin.Context) {
 	// 绑定前端传来的所操作票据的信息
-	var bill Bill
-	err := ctx.ShouldBind(&bill)
-	if err != nil {
-		fmt.Printf("绑定失败: %s\n", err)
-	} else {
-		fmt.Printf("绑定成功: %s\n", err)
+	var bill BillIDRequest
+	if !bindRequest(ctx, &bill) {
+		return
 	}
 	// 调用智能合约中的discountBill方法，修改票据状态
-	results, err := contract.SubmitTransaction("discountBill", bill.BillInfoID, bill.BillInfoMoney, bill.BillInfoType, bill.BillInfoIssueDate, bill.BillInfoDueDate, bill.PubBillID, bill.PubBillName, bill.PayBillID, bill.PayBillName, bill.AcceptBillID, bill.AcceptBillName, bill.HoldBillID, bill.HoldBillName)
+	results, receipt, err := submitTransaction(ctx, "discountBill", bill.BillInfoID)
 	if err != nil {
 		fmt.Printf("Failed to submit transaction: %s\n", err)
-		os.Exit(1)
+		abortWithLedgerError(ctx, err)
+		return
 	}
-	fmt.Println(string(results))
+	respondBill(ctx, results, receipt)
 }
 
-// 构造钱包内部的方法populateWallet
-// For building the wallet
-func populateWallet(wallet *gateway.Wallet) error {
-	credPath := filepath.Join(
-		"..",
-		"..",
-		"test-network",
-		"organizations",
-		"peerOrganizations",
-		"org2.example.com",
-		"users",
-		"User1@org2.example.com",
-		"msp",
+//——————————————————————————————用户存储————————users————————————————————————————————————————
+
+// 用于不存在的用户的哈希
+// The hash compared against for unknown users
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
+// 链下用户存储，以JSON文件保存用户记录
+// The off-chain user store, keeps the user records in a JSON file
+type UserStore struct {
+	mu      sync.RWMutex
+	path    string
+	records map[string]UserRecord
+}
+
+// 打开用户存储，文件不存在时创建默认用户
+// Open the user store, the default users are created when the file does not exist
+func NewUserStore(path string) (*UserStore, error) {
+	store := &UserStore{path: path, records: map[string]UserRecord{}}
+	data, err := ioutil.ReadFile(filepath.Clean(path))
+	if os.IsNotExist(err) {
+		return store, store.seed(defaultUsers)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []UserRecord
+	err = json.Unmarshal(data, &records)
+	if err != nil {
+		return nil, err
+	}
+	hasBank := false
+	migrated := false
+	for _, record := range records {
+		// 旧版本的管理员以银行身份签名，改为使用自己的身份
+		// The admin of the old version signed as the bank, it now uses its own identity
+		if record.Role == Role_Admin && record.CompanyId == bankCompanyID {
+			record.CompanyId = adminCompanyID
+			migrated = true
+		}
+		store.records[record.Username] = record
+		hasBank = hasBank || record.Role == Role_Bank
+	}
+	if migrated {
+		err = store.save()
+		if err != nil {
+			return nil, err
+		}
+	}
+	// 旧版本创建的用户存储只有管理员，补充银行用户
+	// Stores created by the old version only have the admin, the bank user is added
+	if !hasBank {
+		for _, record := range defaultUsers {
+			if record.Role == Role_Bank {
+				if _, ok := store.records[record.Username]; ok {
+					continue
+				}
+				err = store.seed([]UserRecord{record})
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return store, nil
+}
+
+// 系统的默认用户，每个用户以其公司ID对应的身份签名交易
+// The default users, each signs its transactions with the identity of its company ID
+var defaultUsers = []UserRecord{
+	UserRecord{Username: "admin", CompanyName: "管理员", CompanyId: adminCompanyID, Role: Role_Admin},
+	UserRecord{Username: "bank", CompanyName: "银行", CompanyId: "bank", Role: Role_Bank},
+	UserRecord{Username: "alice", CompanyName: "A公司", CompanyId: "acmid", Role: Role_Company},
+	UserRecord{Username: "bob", CompanyName: "B公司", CompanyId: "bcmid", Role: Role_Company},
+	UserRecord{Username: "carle", CompanyName: "C公司", CompanyId: "ccmid", Role: Role_Company},
+}
+
+// 创建用户，初始密码取自环境变量 BMS_INITIAL_PASSWORD，未设置时随机生成并打印一次
+// Create the users. The initial password is read from BMS_INITIAL_PASSWORD,
+// otherwise a random one is generated for each user and printed once
+func (store *UserStore) seed(defaults []UserRecord) error {
+	for _, record := range defaults {
+		password := os.Getenv("BMS_INITIAL_PASSWORD")
+		if password == "" {
+			buf := make([]byte, 8)
+			if _, err := rand.Read(buf); err != nil {
+				return err
+			}
+			password = hex.EncodeToString(buf)
+			fmt.Printf("Initial password of %s: %s\n", record.Username, password)
+		}
+		err := store.Put(record, password)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 保存用户记录，password 不为空时更新其哈希
+// Store a user record, the password hash is updated when password is not empty
+func (store *UserStore) Put(record UserRecord, password string) error {
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		record.PasswordHash = string(hash)
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.records[record.Username] = record
+	return store.save()
+}
+
+// 将所有用户记录写入文件，先写临时文件再替换，避免写入中断时损坏数据
+// Write all the records to the file, through a temporary file so an interrupted write never corrupts it
+func (store *UserStore) save() error {
+	records := make([]UserRecord, 0, len(store.records))
+	for _, record := range store.records {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Username < records[j].Username })
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := store.path + ".tmp"
+	err = ioutil.WriteFile(tmpPath, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, store.path)
+}
+
+// 校验用户名和密码
+// Check the username and password
+func (store *UserStore) Authenticate(username string, password string) (*UserRecord, error) {
+	store.mu.RLock()
+	record, ok := store.records[username]
+	store.mu.RUnlock()
+	if !ok {
+		// 用户不存在时同样计算一次哈希，避免通过响应时间判断用户名是否存在
+		// Still compare a hash for unknown users so the response time does not leak which usernames exist
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return nil, errors.New("invalid username or password")
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(record.PasswordHash), []byte(password))
+	if err != nil {
+		return nil, errors.New("invalid username or password")
+	}
+	return &record, nil
+}
+
+// 查询所有用户的公开信息
+// List the public infos of all the users
+func (store *UserStore) List() []UserInfo {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	infos := make([]UserInfo, 0, len(store.records))
+	for _, record := range store.records {
+		infos = append(infos, record.Info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Username < infos[j].Username })
+	return infos
+}
+
+// 用户记录的公开信息
+// The public part of a user record
+func (record *UserRecord) Info() UserInfo {
+	return UserInfo{
+		Username:    record.Username,
+		CompanyName: record.CompanyName,
+		CompanyId:   record.CompanyId,
+		Role:        record.Role,
+	}
+}
+
+//——————————————————————————————登录会话————————sessions————————————————————————————————————
+
+// 登录会话，令牌为随机生成的不透明字符串
+// A login session, the token is an opaque random string
+type Session struct {
+	Token       string    `json:"Token"`       // 令牌
+	Username    string    `json:"Username"`    // 用户名
+	CompanyName string    `json:"CompanyName"` // 公司名称
+	CompanyId   string    `json:"CompanyId"`   // 公司ID
+	Role        string    `json:"Role"`        // 角色
+	ExpiresAt   time.Time `json:"ExpiresAt"`   // 过期时间
+}
+
+// 内存中的会话存储
+// The in-memory session store
+type SessionStore struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	sessions map[string]*Session
+}
+
+func NewSessionStore(ttl time.Duration) *SessionStore {
+	return &SessionStore{ttl: ttl, sessions: map[string]*Session{}}
+}
+
+// 为用户创建新的会话
+// Create a new session for the user
+func (store *SessionStore) Create(record *UserRecord) (*Session, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	session := &Session{
+		Token:       hex.EncodeToString(buf),
+		Username:    record.Username,
+		CompanyName: record.CompanyName,
+		CompanyId:   record.CompanyId,
+		Role:        record.Role,
+		ExpiresAt:   time.Now().Add(store.ttl),
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.sessions[session.Token] = session
+	return session, nil
+}
+
+// 查询令牌对应的会话，过期的会话会被删除
+// Look up the session of a token, expired sessions are removed
+func (store *SessionStore) Get(token string) (*Session, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	session, ok := store.sessions[token]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(session.ExpiresAt) {
+		delete(store.sessions, token)
+		return nil, false
+	}
+	return session, true
+}
+
+// 删除会话
+// Remove a session
+func (store *SessionStore) Delete(token string) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.sessions, token)
+}
+
+// 用有效的令牌换取新令牌
+// Exchange a valid token for a new one
+func (store *SessionStore) Refresh(token string) (*Session, error) {
+	session, ok := store.Get(token)
+	if !ok {
+		return nil, errors.New("invalid or expired token")
+	}
+	store.Delete(token)
+	return store.Create(&UserRecord{
+		Username:    session.Username,
+		CompanyName: session.CompanyName,
+		CompanyId:   session.CompanyId,
+		Role:        session.Role,
+	})
+}
+
+//——————————————————————————————区块链连接————————ledger————————————————————————————————————
+
+// 按身份缓存网关连接，每个公司使用钱包中自己的身份签名交易
+// Caches one gateway connection per identity, every company signs its transactions with its own wallet identity
+type LedgerClient struct {
+	mu        sync.Mutex
+	wallet    *gateway.Wallet
+	ccpPath   string
+	gateways  map[string]*gateway.Gateway
+	networks  map[string]*gateway.Network
+	contracts map[string]*gateway.Contract
+}
+
+func NewLedgerClient(wallet *gateway.Wallet, ccpPath string) *LedgerClient {
+	return &LedgerClient{
+		wallet:    wallet,
+		ccpPath:   ccpPath,
+		gateways:  map[string]*gateway.Gateway{},
+		networks:  map[string]*gateway.Network{},
+		contracts: map[string]*gateway.Contract{},
+	}
+}
+
+// 获取以 label 身份连接的智能合约，首次使用时从登记目录导入身份并建立连接
+// The smart contract connected as the label's identity. On first use the identity
+// is imported from the enrollment directory and the gateway is connected
+func (client *LedgerClient) Contract(label string) (*gateway.Contract, error) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if contract, ok := client.contracts[label]; ok {
+		return contract, nil
+	}
+	// 向钱包中添加用户的证书，生成目录等
+	// Add the User Certificate, content... to the wallet
+	if !client.wallet.Exists(label) {
+		err := populateWallet(client.wallet, label)
+		if err != nil {
+			return nil, fmt.Errorf("failed to populate wallet for %s: %s", label, err)
+		}
+	}
+	// 从钱包中获取身份，创立链接
+	gw, err := gateway.Connect(
+		gateway.WithConfig(config.FromFile(client.ccpPath)),
+		gateway.WithIdentity(client.wallet, label),
 	)
+	if err != nil {
+		return nil, err
+	}
+	// 连接到通道
+	// connect to the Blockchain Network
+	network, err := gw.GetNetwork(channelName)
+	if err != nil {
+		gw.Close()
+		return nil, err
+	}
+	// 获取链上代码（智能合约）名称
+	// Get smart contract name
+	contract := network.GetContract(chaincodeName)
+	client.gateways[label] = gw
+	client.networks[label] = network
+	client.contracts[label] = contract
+	return contract, nil
+}
+
+// 获取以 label 身份连接的系统查询链码 qscc，用于按交易ID查询交易和区块
+// The system query chaincode (qscc) connected as the label's identity, used to look up
+// transactions and blocks by transaction ID
+func (client *LedgerClient) QueryContract(label string) (*gateway.Contract, error) {
+	_, err := client.Contract(label)
+	if err != nil {
+		return nil, err
+	}
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.networks[label].GetContract("qscc"), nil
+}
+
+// 关闭所有连接
+// Close all the connections
+func (client *LedgerClient) Close() {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	for label, gw := range client.gateways {
+		gw.Close()
+		delete(client.gateways, label)
+		delete(client.networks, label)
+		delete(client.contracts, label)
+	}
+}
+
+// 当前登录用户所属公司身份的智能合约
+// The smart contract connected as the company of the logged in user
+func contractFor(ctx *gin.Context) (*gateway.Contract, error) {
+	session := principal(ctx)
+	if session == nil {
+		return nil, errors.New("no logged in user")
+	}
+	return ledger.Contract(session.CompanyId)
+}
+
+// 提交交易：背书后发送给排序节点并写入账本，用于修改账本的操作，同时返回交易回执
+// Submit a transaction: endorsed, ordered and committed to the ledger. Used for writes, the receipt is returned too
+func submitTransaction(ctx *gin.Context, name string, args ...string) ([]byte, *Receipt, error) {
+	contract, err := contractFor(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	txn, err := contract.CreateTransaction(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	commit := txn.RegisterCommitEvent()
+	results, err := txn.Submit(args...)
+	// Submit 返回时提交事件已经到达（若交易已发送给排序节点）
+	// The commit event has arrived when Submit returns, if the transaction reached the orderer
+	var receipt *Receipt
+	select {
+	case status, ok := <-commit:
+		if ok {
+			receipt = &Receipt{TxID: status.TxID, Status: status.TxValidationCode.String(), BlockNumber: status.BlockNumber}
+			// 带上验证码，例如 MVCC_READ_CONFLICT，便于转换为HTTP状态码
+			// Prefix the validation code such as MVCC_READ_CONFLICT so it maps to an HTTP status
+			if err != nil && status.TxValidationCode != peer.TxValidationCode_VALID {
+				err = fmt.Errorf("%s: %s", status.TxValidationCode, err)
+			}
+		}
+	default:
+	}
+	// 提交成功后从账本读取交易时间；查询失败时仍返回提交事件中的信息
+	// Read the transaction time back from the ledger once committed, if that fails the
+	// receipt still carries what the commit event reported
+	if err == nil && receipt != nil {
+		committed, lookupErr := lookupTransaction(ctx, receipt.TxID)
+		if lookupErr == nil {
+			receipt = committed
+		} else {
+			fmt.Printf("Failed to look up transaction %s: %s\n", receipt.TxID, lookupErr)
+		}
+	}
+	return results, receipt, err
+}
+
+// 通过系统查询链码 qscc 按交易ID查询已提交的交易，得到验证状态、区块号和交易时间
+// Look up a committed transaction by ID through the system query chaincode (qscc),
+// giving its validation status, block number and timestamp
+func lookupTransaction(ctx *gin.Context, txID string) (*Receipt, error) {
+	session := principal(ctx)
+	if session == nil {
+		return nil, errors.New("no logged in user")
+	}
+	qscc, err := ledger.QueryContract(session.CompanyId)
+	if err != nil {
+		return nil, err
+	}
+	// 交易本身：验证码及交易信封
+	// The transaction itself: its validation code and envelope
+	payload, err := qscc.EvaluateTransaction("GetTransactionByID", channelName, txID)
+	if err != nil {
+		return nil, err
+	}
+	var processed peer.ProcessedTransaction
+	err = proto.Unmarshal(payload, &processed)
+	if err != nil {
+		return nil, err
+	}
+	if processed.TransactionEnvelope == nil {
+		return nil, fmt.Errorf("transaction %s does not exist", txID)
+	}
+	var txPayload common.Payload
+	err = proto.Unmarshal(processed.TransactionEnvelope.Payload, &txPayload)
+	if err != nil {
+		return nil, err
+	}
+	if txPayload.Header == nil {
+		return nil, fmt.Errorf("transaction %s has no header", txID)
+	}
+	var channelHeader common.ChannelHeader
+	err = proto.Unmarshal(txPayload.Header.ChannelHeader, &channelHeader)
+	if err != nil {
+		return nil, err
+	}
+	// 交易所在的区块
+	// The block holding the transaction
+	payload, err = qscc.EvaluateTransaction("GetBlockByTxID", channelName, txID)
+	if err != nil {
+		return nil, err
+	}
+	var block common.Block
+	err = proto.Unmarshal(payload, &block)
+	if err != nil {
+		return nil, err
+	}
+	receipt := &Receipt{
+		TxID:   channelHeader.TxId,
+		Status: peer.TxValidationCode(processed.ValidationCode).String(),
+	}
+	if block.Header != nil {
+		receipt.BlockNumber = block.Header.Number
+	}
+	if ts := channelHeader.Timestamp; ts != nil {
+		receipt.Timestamp = time.Unix(ts.Seconds, int64(ts.Nanos)).UTC().Format(time.RFC3339Nano)
+	}
+	return receipt, nil
+}
+
+// 评估交易：只在节点上执行并返回结果，不经过排序节点，用于只读查询
+// Evaluate a transaction: executed on a peer only and never ordered. Used for read-only queries
+func evaluateTransaction(ctx *gin.Context, name string, args ...string) ([]byte, error) {
+	contract, err := contractFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return contract.EvaluateTransaction(name, args...)
+}
+
+// 构造钱包内部的方法populateWallet
+// For building the wallet, import the identity of enrollment/<label>/msp
+func populateWallet(wallet *gateway.Wallet, label string) error {
+	credPath := filepath.Join(enrollmentDir, label, "msp")
 
 	certPath := filepath.Join(credPath, "signcerts", "cert.pem")
 	// read the certificate pem
@@ -681,15 +1991,120 @@ func populateWallet(wallet *gateway.Wallet) error {
 		return err
 	}
 
-	identity := gateway.NewX509Identity("Org2MSP", string(cert), string(key))
+	// MSP ID 取自 enrollment/<label>/mspid 文件
+	// The MSP ID is read from enrollment/<label>/mspid
+	mspID := defaultMSPID
+	mspIDBytes, err := ioutil.ReadFile(filepath.Join(enrollmentDir, label, "mspid"))
+	if err == nil {
+		mspID = strings.TrimSpace(string(mspIDBytes))
+	}
+
+	identity := gateway.NewX509Identity(mspID, string(cert), string(key))
 
-	err = wallet.Put("appUser2", identity)
+	err = wallet.Put(label, identity)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+//——————————————————————————————追索————————recourse———————————————————————————————————————
+// 查看可追索当事人：出票人、承兑人及前手背书人
+// Query the parties the holder can claim against: the drawer, the acceptor and the prior endorsers
+func queryLiableParties(ctx *gin.Context) {
+	var bill BillIDRequest
+	if !bindRequest(ctx, &bill) {
+		return
+	}
+	results, err := evaluateTransaction(ctx, "queryLiableParties", bill.BillInfoID)
+	if err != nil {
+		fmt.Printf("Failed to evaluate transaction: %s\n", err)
+		abortWithLedgerError(ctx, err)
+		return
+	}
+	parties := []LiableParty{}
+	if len(results) > 0 {
+		err = json.Unmarshal(results, &parties)
+		if err != nil {
+			abortWithError(ctx, http.StatusBadGateway, "ledger_error", err.Error())
+			return
+		}
+	}
+	respond(ctx, parties, nil)
+}
+
+// 发起追索 -- 持票人向可追索当事人追索
+// Claim recourse against a liable party
+func claimRecourse(ctx *gin.Context) {
+	var request RecourseRequest
+	if !bindRequest(ctx, &request) {
+		return
+	}
+	results, receipt, err := submitTransaction(ctx, "claimRecourse", request.BillInfoID, request.LiableID)
+	if err != nil {
+		fmt.Printf("Failed to submit transaction: %s\n", err)
+		abortWithLedgerError(ctx, err)
+		return
+	}
+	respondBill(ctx, results, receipt)
+}
+
+// 待答复的追索 -- 向自己发起的追索
+// Query the recourse claims made against the company
+func checkWaitRecourseBills(ctx *gin.Context) {
+	// 登录用户的公司ID  Company ID of the logged in user
+	companyID := principal(ctx).CompanyId
+	queryBills(ctx, "queryWaitRecourseBills", companyID)
+}
+
+// 同意清偿 -- 被追索人同意向持票人付款
+// Agree to pay the holder
+func agreeRecourse(ctx *gin.Context) {
+	var bill BillIDRequest
+	if !bindRequest(ctx, &bill) {
+		return
+	}
+	results, receipt, err := submitTransaction(ctx, "agreeRecourse", bill.BillInfoID)
+	if err != nil {
+		fmt.Printf("Failed to submit transaction: %s\n", err)
+		abortWithLedgerError(ctx, err)
+		return
+	}
+	respondBill(ctx, results, receipt)
+}
+
+// 拒绝清偿 -- 被追索人拒绝付款并给出理由
+// Refuse to pay the holder, with the reason
+func disagreeRecourse(ctx *gin.Context) {
+	var request DishonorRequest
+	if !bindRequest(ctx, &request) {
+		return
+	}
+	results, receipt, err := submitTransaction(ctx, "disagreeRecourse", request.BillInfoID, request.Reason)
+	if err != nil {
+		fmt.Printf("Failed to submit transaction: %s\n", err)
+		abortWithLedgerError(ctx, err)
+		return
+	}
+	respondBill(ctx, results, receipt)
+}
+
+// 确认收款 -- 持票人确认已收到被追索人的付款，票据结清
+// Confirm the liable party paid, the bill is settled
+func settleRecourse(ctx *gin.Context) {
+	var bill BillIDRequest
+	if !bindRequest(ctx, &bill) {
+		return
+	}
+	results, receipt, err := submitTransaction(ctx, "settleRecourse", bill.BillInfoID)
+	if err != nil {
+		fmt.Printf("Failed to submit transaction: %s\n", err)
+		abortWithLedgerError(ctx, err)
+		return
+	}
+	respondBill(ctx, results, receipt)
+}
+
 // 跨域访问
 // Solve CORS Problem
 func Cors() gin.HandlerFunc {
@@ -4,11 +4,14 @@ import (
 	"encoding/json"
 	"fmt"
 
-	//"time"
 	"errors"
-	// "strconv"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 	"github.com/hyperledger/fabric/common/flogging"
 )
@@ -18,7 +21,7 @@ var chaincodeLogger = flogging.MustGetLogger("EndorseBillChaincode")
 
 const (
 	// 票据状态
-	// Set 6 state to distinguish different type of bills
+	// Set 13 state to distinguish different type of bills
 
 	// 做成状态
 	// "Made" state, waiting for banks to approve
@@ -44,6 +47,34 @@ const (
 	// "billfail" state, waiting for paying fail
 	BillInfo_State_BillFail = "billfail"
 
+	// 到期状态，已到到期日，等待提示付款
+	// "matured" state, the due date has come and the bill waits for presentment
+	BillInfo_State_Matured = "matured"
+
+	// 逾期状态，到期后超过提示付款期仍未付款
+	// "overdue" state, still unpaid after the presentment period following the due date
+	BillInfo_State_Overdue = "overdue"
+
+	// 提示付款状态，持票人已向承兑人提示付款，等待付款
+	// "presented" state, the holder presented the bill to the pay user and waits for payment
+	BillInfo_State_Presented = "presented"
+
+	// 已结清状态，承兑人已付款，票据生命周期结束
+	// "settled" state, the pay user paid the bill and its life is complete
+	BillInfo_State_Settled = "settled"
+
+	// 拒绝付款状态，承兑人拒绝付款
+	// "dishonored" state, the pay user refused to pay
+	BillInfo_State_Dishonored = "dishonored"
+
+	// 追索状态，持票人已向被追索人发起追索，等待其同意
+	// "recourse" state, the holder claimed against a liable party and waits for its answer
+	BillInfo_State_Recourse = "recourse"
+
+	// 同意清偿状态，被追索人已同意清偿，等待持票人确认收款
+	// "recourseagreed" state, the liable party agreed to pay and the holder is to confirm the payment
+	BillInfo_State_RecourseAgreed = "recourseagreed"
+
 	// 票据操作提示信息
 	// Set 6 message type to note to user, when succeed or fail for different operations
 
@@ -61,20 +92,218 @@ const (
 	// Pay success / fail
 	Message_WaitPaySuccess = "waitpaysuccess"
 	Message_WaitPayFail    = "waitpayfail"
+
+	// 付款成功/失败
+	// Settle success / fail
+	Message_SettleSuccess = "settlesuccess"
+	Message_SettleFail    = "settlefail"
+
+	// 追索同意/拒绝
+	// Recourse agreed / refused
+	Message_RecourseSuccess = "recoursesuccess"
+	Message_RecourseFail    = "recoursefail"
+)
+
+const (
+	// 追索记录状态
+	// Status of a recourse claim
+	Recourse_Claimed = "claimed"
+	Recourse_Agreed  = "agreed"
+	Recourse_Refused = "refused"
+	Recourse_Settled = "settled"
+)
+
+const (
+	// 背书记录类型
+	// Type of an endorsement record
+	Endorsement_Endorse  = "endorse"
+	Endorsement_Discount = "discount"
+)
+
+const (
+	// 索引组合键，使查询不依赖 CouchDB，在 LevelDB 上同样可用
+	// Index composite keys, so the queries don't depend on CouchDB and work on LevelDB too
+
+	// 当事人索引 party~当事人角色~当事人ID~票据状态~票据编号
+	// Party index: party~role~partyID~state~billID
+	IndexKey_Party = "party"
+
+	// 状态索引 state~票据状态~票据编号
+	// State index: state~state~billID
+	IndexKey_State = "state"
+
+	// 当事人角色
+	// Party roles in the party index
+	Party_Pub      = "pub"
+	Party_Pay      = "pay"
+	Party_Accept   = "accept"
+	Party_Hold     = "hold"
+	Party_Endorsed = "endorsed"
+	Party_Recourse = "recourse"
+)
+
+const (
+	// CouchDB 索引名称，定义在 META-INF/statedb/couchdb/indexes 中，设计文档名为 <索引名>Doc
+	// CouchDB index names, defined in META-INF/statedb/couchdb/indexes. The design document of each is <name>Doc
+	Index_State     = "indexState"
+	Index_BillID    = "indexBillID"
+	Index_IssueDate = "indexIssueDate"
+	Index_DueDate   = "indexDueDate"
+	Index_BillType  = "indexBillType"
+	Index_Currency  = "indexCurrency"
+	Index_Amount    = "indexAmount"
+)
+
+// 票据搜索可用的排序字段及其索引
+// The fields bill searches can sort on, and the index of each
+var searchSortIndexes = map[string]string{
+	"BillInfoID":        Index_BillID,
+	"BillInfoIssueDate": Index_IssueDate,
+	"BillInfoDueDate":   Index_DueDate,
+}
+
+// 不排序时按此顺序选用第一个出现在查询条件中的字段的索引。当事人条件是各当事人字段的 $or，
+// CouchDB 无法为其使用索引；只按当事人搜索时将扫描所有票据，与其他条件同时使用时由该条件的索引缩小范围
+// Without a sort, the index of the first of these fields found in the selector is used. The party filter
+// is an $or over the party fields which CouchDB cannot serve from an index: searching by party alone scans
+// every bill, combined with another filter the index of that filter narrows the scan
+var searchFilterIndexes = []struct {
+	field string
+	index string
+}{
+	{"State", Index_State},
+	{"BillInfoID", Index_BillID},
+	{"BillInfoType", Index_BillType},
+	{"BillInfoMoney.currency", Index_Currency},
+	{"BillInfoMoney.fen", Index_Amount},
+	{"BillInfoDueDate", Index_DueDate},
+	{"BillInfoIssueDate", Index_IssueDate},
+}
+
+const (
+	// 默认币种，人民币
+	// The default currency, renminbi
+	DefaultCurrency = "CNY"
+
+	// 票据金额上限（分），即一百亿元
+	// The largest amount of a bill in fen, ten billion yuan
+	MaxAmountFen = 1000000000000
+)
+
+const (
+	// 票据日期格式
+	// The format of bill dates
+	DateLayout = "2006-01-02"
+
+	// 票据最长期限（月），电子商业汇票最长一年
+	// The longest term of a bill in months, one year for electronic commercial drafts
+	MaxBillTermMonths = 12
+
+	// 提示付款期（天），到期后超过此期限仍未付款即为逾期
+	// The presentment period in days, a bill still unpaid this long after its due date is overdue
+	PresentmentPeriodDays = 10
+
+	// 时间戳格式，统一使用UTC，与后端的交易回执一致
+	// The format of timestamps, always in UTC like the backend's transaction receipts
+	TimestampLayout = time.RFC3339Nano
+)
+
+// 票据日期所在时区，北京时间
+// Bill dates are calendar dates in China Standard Time
+var billLocation = time.FixedZone("CST", 8*60*60)
+
+const (
+	// 分页查询每页的最大数量
+	// The largest page size of paginated queries
+	MaxPageSize = 200
+)
+
+const (
+	// 账本中的数据类型，作为组合键的 objectType 以及 docType 字段
+	// Entity types on the ledger, used as the objectType of composite keys and as the docType field
+	DocType_Bill    = "bill"
+	DocType_Company = "company"
+)
+
+const (
+	// 调用者证书中的属性及角色
+	// Attributes in the caller's certificate (set when registering the identity at the CA) and the roles
+
+	// 公司ID属性，对应票据中的 PubBillID / PayBillID / HoldBillID 等
+	// Company ID attribute, matched against PubBillID / PayBillID / HoldBillID ...
+	Identity_Attr_CompanyID = "companyId"
+
+	// 公司名称属性
+	// Company name attribute
+	Identity_Attr_CompanyName = "companyName"
+
+	// 角色属性
+	// Role attribute
+	Identity_Attr_Role = "role"
+
+	// 银行 / 企业 / 管理员
+	// Bank / company / admin roles
+	Identity_Role_Bank    = "bank"
+	Identity_Role_Company = "company"
+	Identity_Role_Admin   = "admin"
+
+	// 公司登记信息所属的MSP。链码定义须经各组织管理员批准，此值即为通道认可的配置：尚未绑定的登记信息
+	// 只能由该MSP的银行或管理员初始化，示例公司登记在该MSP下
+	// The MSP owning the company registry. The chaincode definition is approved by the admins of every
+	// organization, so this is configuration the channel agreed on: only a bank or admin of this MSP can
+	// initialize an unbound registry, and the sample companies are registered under it
+	RegistryMSP = "Org2MSP"
 )
 
 type SmartContract struct {
 	contractapi.Contract
 }
 
+// 只读的查询方法，在合约元数据中标记为 evaluate，客户端不需要将其提交给排序节点
+// The read-only query functions, tagged as evaluate in the contract metadata so clients never order them
+func (s *SmartContract) GetEvaluateTransactions() []string {
+	return []string{
+		"QueryAllCompanies",
+		"QueryAllBill",
+		"QueryBillById",
+		"QueryHistoryById",
+		"QueryBillHistoryById",
+		"QueryWaitDiscountBills",
+		"QueryWaitPayBills",
+		"QueryAllPayBills",
+		"QueryAllAcceptBills",
+		"QueryAllHoldBills",
+		"QueryWaitEndorseBills",
+		"QueryAllBillWithPagination",
+		"QueryWaitDiscountBillsWithPagination",
+		"QueryWaitPayBillsWithPagination",
+		"QueryAllPayBillsWithPagination",
+		"QueryAllAcceptBillsWithPagination",
+		"QueryAllHoldBillsWithPagination",
+		"QueryWaitEndorseBillsWithPagination",
+		"SearchBills",
+		"QueryDueBills",
+		"QueryDueBillsWithPagination",
+		"QueryWaitSettleBills",
+		"QueryWaitSettleBillsWithPagination",
+		"QueryLiableParties",
+		"QueryWaitRecourseBills",
+		"QueryWaitRecourseBillsWithPagination",
+		"QueryMyBillByIdAndPay",
+		"QueryMyBillByIdAndUnpay",
+	}
+}
+
 // 票据 Bill struct
 type Bill struct {
+	DocType string `json:"docType"` //数据类型  Entity type, always "bill"
 	//票据基本信息
 	BillInfoID        string `json:"BillInfoID"`        //票据号码  Bill ID
-	BillInfoMoney     string `json:"BillInfoMoney"`     //票据金额  Bill Amount
+	BillInfoMoney     Amount `json:"BillInfoMoney"`     //票据金额  Bill Amount
 	BillInfoType      string `json:"BillInfoType"`      //票据类型	Bill Type
 	BillInfoIssueDate string `json:"BillInfoIssueDate"` //票据出票日期  Bill issue date
 	BillInfoDueDate   string `json:"BillInfoDueDate"`   //票据到期日期  Bill due date
+	NonTransferable   bool   `json:"NonTransferable"`   //不得转让，不能再背书或贴现  "Not transferable", the bill can no longer be endorsed or discounted
 	//出票人信息  People info (who public this bill)
 	PubBillID   string `json:"PubBillID"`   //出票人证件号码  Personal ID
 	PubBillName string `json:"PubBillName"` //出票人名称	Personal Name
@@ -88,10 +317,23 @@ type Bill struct {
 	HoldBillID   string `json:"HoldBillID"`   //持票人证件号码  Personal ID
 	HoldBillName string `json:"HoldBillName"` //持票人名称  Personal Name
 	//背书操作--信息	Attributes for endorsement
-	EndorsedID   string `json:"EndorsedID"`   // 被背书人证件号码   Personal ID
-	EndorsedName string `json:"EndorsedName"` // 被背书人名称  Personal Name
-	Message      string `json:"Message"`      // 操作提示信息  Message for User
-	State        string `json:"State"`        //票据状态  Bill State
+	EndorsedID          string        `json:"EndorsedID"`                                  // 被背书人证件号码   Personal ID
+	EndorsedName        string        `json:"EndorsedName"`                                // 被背书人名称  Personal Name
+	EndorsedRestrictive bool          `json:"EndorsedRestrictive"`                         // 申请中的背书是否记载"不得转让"  Whether the pending endorsement is restrictive
+	Endorsements        []Endorsement `json:"Endorsements,omitempty" metadata:",optional"` // 背书记录，按时间顺序  The endorsement chain, oldest first
+	Message             string        `json:"Message"`                                     // 操作提示信息  Message for User
+	State               string        `json:"State"`                                       //票据状态  Bill State
+	//最近一次修改信息  Who changed the bill last
+	UpdatedBy    string `json:"UpdatedBy"`    // 最近修改者的公司ID  Company ID of the last invoker
+	UpdatedByMSP string `json:"UpdatedByMSP"` // 最近修改者的MSP  MSP of the last invoker
+	//提示付款信息  Presentment for payment
+	PresentedAt    string `json:"PresentedAt"`    // 提示付款时间  When the bill was presented
+	SettledAt      string `json:"SettledAt"`      // 付款或拒绝付款时间  When the payment was made or refused
+	DishonorReason string `json:"DishonorReason"` // 拒绝付款理由  Why the payment was refused
+	//追索信息  Recourse
+	RecourseID   string           `json:"RecourseID"`                               // 当前被追索人证件号码  Personal ID of the party currently claimed against
+	RecourseName string           `json:"RecourseName"`                             // 当前被追索人名称  Personal Name of the party currently claimed against
+	Recourses    []RecourseRecord `json:"Recourses,omitempty" metadata:",optional"` // 追索记录  Every recourse claim made on the bill
 
 	// Biil Operation History
 	// History []HistoryItem `json:"History"`   //背书历史
@@ -99,410 +341,2348 @@ type Bill struct {
 	// We do not need to set an attribute, we can search by call the smart contract
 }
 
-// 登陆信息数据
-// SignInfo struct
-type SignInfo struct {
-	Username    string `json:"Username"`    // 用户名
-	Password    string `json:"Password"`    // 密码
-	CompanyName string `json:"CompanyName"` // 公司名称
-	CompanyId   string `json:"CompanyId"`   //  公司ID
+// 一次背书记录，贴现也视为向银行的背书
+// One endorsement in the chain; a discount is recorded as an endorsement to the bank
+type Endorsement struct {
+	Type         string `json:"type"`         // endorse / discount
+	EndorserID   string `json:"endorserId"`   // 背书人证件号码  Personal ID of the endorser
+	EndorserName string `json:"endorserName"` // 背书人名称  Personal Name of the endorser
+	EndorseeID   string `json:"endorseeId"`   // 被背书人证件号码  Personal ID of the endorsee
+	EndorseeName string `json:"endorseeName"` // 被背书人名称  Personal Name of the endorsee
+	Restrictive  bool   `json:"restrictive"`  // 是否记载"不得转让"  Whether the endorser wrote "not transferable"
+	Timestamp    string `json:"timestamp"`    // 签收时间（UTC，RFC3339）  When the endorsee signed, in UTC RFC3339
+	TxID         string `json:"txId"`         // 签收交易ID  Transaction ID of the signature
 }
 
-func isExisted(ctx contractapi.TransactionContextInterface, key string) bool {
-	val, err := ctx.GetStub().GetState(key)
-	if err != nil {
-		fmt.Printf("Error: %s\n", err)
-	}
-	if len(val) == 0 {
-		return false
-	}
-	return true
+// 一次追索记录
+// One recourse claim
+type RecourseRecord struct {
+	LiableID   string `json:"liableId"`   // 被追索人证件号码  Personal ID of the liable party
+	LiableName string `json:"liableName"` // 被追索人名称  Personal Name of the liable party
+	FromState  string `json:"fromState"`  // 发起追索时的票据状态  The bill state the claim was made from
+	Status     string `json:"status"`     // claimed / agreed / refused / settled
+	Reason     string `json:"reason"`     // 拒绝理由  Why the claim was refused
+	ClaimedAt  string `json:"claimedAt"`  // 发起时间  When the claim was made
+	AnsweredAt string `json:"answeredAt"` // 同意或拒绝时间  When the claim was agreed or refused
+	SettledAt  string `json:"settledAt"`  // 结清时间  When the holder confirmed the payment
 }
 
-// InitLedger adds a base set of Bills to the ledger
-func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface) error {
-	bills := []Bill{
-		Bill{BillInfoID: "POA10000998", BillInfoMoney: "2000", BillInfoType: "A", BillInfoIssueDate: "2021-01-20", BillInfoDueDate: "2022-01-20", PubBillID: "bank", PubBillName: "银行", PayBillID: "ccmid", PayBillName: "C公司", AcceptBillID: "acmid", AcceptBillName: "A公司", HoldBillID: "acmid", HoldBillName: "A公司", EndorsedID: "", EndorsedName: "", Message: "", State: "public"},
-		Bill{BillInfoID: "POB10000998", BillInfoMoney: "3000", BillInfoType: "B", BillInfoIssueDate: "2021-02-10", BillInfoDueDate: "2022-01-20", PubBillID: "bank", PubBillName: "银行", PayBillID: "acmid", PayBillName: "A公司", AcceptBillID: "bcmid", AcceptBillName: "B公司", HoldBillID: "bcmid", HoldBillName: "B公司", EndorsedID: "", EndorsedName: "", Message: "", State: "public"},
-		Bill{BillInfoID: "POC10000998", BillInfoMoney: "40000", BillInfoType: "C", BillInfoIssueDate: "2020-08-23", BillInfoDueDate: "2022-01-20", PubBillID: "bank", PubBillName: "银行", PayBillID: "bmcid", PayBillName: "B公司", AcceptBillID: "ccmid", AcceptBillName: "C公司", HoldBillID: "ccmid", HoldBillName: "C公司", EndorsedID: "", EndorsedName: "", Message: "", State: "public"},
-	}
+// 可被追索的当事人
+// A party the holder can claim against
+type LiableParty struct {
+	ID   string `json:"id"`   // 证件号码  Personal ID
+	Name string `json:"name"` // 名称  Personal Name
+	Role string `json:"role"` // drawer / acceptor / endorser
+}
 
-	for _, bill := range bills {
-		billAsBytes, _ := json.Marshal(bill)
-		err := ctx.GetStub().PutState(bill.BillInfoID, billAsBytes)
+// 票据历史记录中的一条，包含交易信息及当时的票据
+// One entry of a bill's history: the transaction metadata and the bill as written by it
+type HistoryEntry struct {
+	TxID      string `json:"txId"`                                 // 交易ID  Transaction ID
+	Timestamp string `json:"timestamp"`                            // 交易时间（UTC，RFC3339）  Transaction time in UTC RFC3339
+	IsDelete  bool   `json:"isDelete"`                             // 是否为删除操作  Whether the transaction deleted the bill
+	MSPID     string `json:"mspId"`                                // 调用者的MSP  MSP of the invoker
+	Invoker   string `json:"invoker"`                              // 调用者的公司ID  Company ID of the invoker
+	Value     *Bill  `json:"value,omitempty" metadata:",optional"` // 交易写入的票据，删除时为空  The bill written, nil when deleted
+}
 
-		if err != nil {
-			return fmt.Errorf("Failed to put to init. %s", err.Error())
-		}
-	}
+// 分页查询结果，Bookmark 用于获取下一页
+// One page of bills, pass Bookmark back to fetch the next page
+type PaginatedBills struct {
+	Bills               []Bill `json:"bills"`               // 本页票据  Bills of this page
+	FetchedRecordsCount int32  `json:"fetchedRecordsCount"` // 本页数量  Number of bills fetched
+	Bookmark            string `json:"bookmark"`            // 下一页的书签  Bookmark of the next page
+}
 
-	// This is the init function for store some infos to the Blockchain Network at the beginning of running this system
-	// By using this, we store the Users' info to the Blockchain Network, which help us to finish the admin and authorization function.
-	SignInfos := []SignInfo{
-		SignInfo{Username: "admin", Password: "123456", CompanyName: "管理员", CompanyId: "bank"},
-		SignInfo{Username: "alice", Password: "123456", CompanyName: "A公司", CompanyId: "acmid"},
-		SignInfo{Username: "bob", Password: "123456", CompanyName: "B公司", CompanyId: "bcmid"},
-		SignInfo{Username: "carle", Password: "123456", CompanyName: "C公司", CompanyId: "ccmid"},
-	}
+// 金额，以分为单位的整数保存，避免浮点误差
+// An amount of money, kept as an integer number of fen (minor units) so no rounding ever happens
+type Amount struct {
+	Fen      int64  `json:"fen"`                                    // 金额（分）  Amount in fen
+	Currency string `json:"currency"`                               // 币种代码，例如 CNY  ISO 4217 currency code, e.g. CNY
+	Invalid  string `json:"invalid,omitempty" metadata:",optional"` // 无法识别的旧版本金额，原样保留  A legacy amount which could not be parsed, as stored
+}
 
-	for _, signinfo := range SignInfos {
-		signinfoAsBytes, _ := json.Marshal(signinfo)
-		err := ctx.GetStub().PutState(signinfo.Username, signinfoAsBytes)
+// 兼容旧版本以字符串保存的金额，例如 "2000"，币种视为人民币。旧版本不校验金额，无法识别的金额
+// 不会使票据无法读取，而是原样保留在 Invalid 中，由 RebuildBillIndexes 标记
+// Also accept the amounts older versions stored as decimal strings such as "2000", in CNY. Older
+// versions never checked the amount, so one that can't be parsed doesn't make the bill unreadable:
+// it is kept as stored in Invalid and RebuildBillIndexes flags the bill
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	var legacy string
+	if json.Unmarshal(data, &legacy) == nil {
+		if legacy == "" {
+			*a = Amount{}
+			return nil
+		}
+		amount, err := parseAmount(legacy, DefaultCurrency)
 		if err != nil {
-			return fmt.Errorf("Failed to put to init. %s", err.Error())
+			*a = Amount{Invalid: legacy}
+			return nil
 		}
+		*a = amount
+		return nil
+	}
+	type plainAmount Amount
+	if json.Unmarshal(data, (*plainAmount)(a)) != nil {
+		*a = Amount{Invalid: string(data)}
 	}
-
 	return nil
 }
 
-// 登录信息提取
-// Quert User's infos
-func (s *SmartContract) QuerySignInfo(ctx contractapi.TransactionContextInterface) ([]SignInfo, error) {
-	// 根据范围查询，查询系统中所有的用户信息
-	// Set the search rage by StartKey and endKey to distinguish among personal infos and bill infos
-	startKey := ""
-	endKey := ""
-	resultsIterator, err := ctx.GetStub().GetStateByRange(startKey, endKey)
+// 以两位小数显示金额
+// The amount as a decimal string with two places
+func (a Amount) String() string {
+	return fmt.Sprintf("%d.%02d", a.Fen/100, a.Fen%100)
+}
+
+// 解析十进制金额字符串，最多两位小数，必须为正数
+// Parse a positive decimal amount with at most two places
+func parseAmount(value string, currency string) (Amount, error) {
+	fen, err := parseFen(value)
 	if err != nil {
-		return nil, err
-	}
-	defer resultsIterator.Close()
-	// 声明SignInfo类型结构体数组，接收返回数据
-	// Receive the infos
-	var results []SignInfo
-	// 迭代上述查询到的结果，处理其中的信息
-	// use Iterator to deal with infos
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
-		var signinfo SignInfo
-		// 将其中一条数据反序列化，存入SignInfo类型变量中
-		// deserialize
-		err = json.Unmarshal(queryResponse.Value, &signinfo)
-		if err != nil {
-			return nil, err
-		}
-		// 将存好一条信息的SignInfo类型变量加入SignInfo类型结构体数组
-		// Append to the result when finishing dealing with a bill
-		results = append(results, signinfo)
+		return Amount{}, err
 	}
-	// 返回查询到的结果
-	return results, nil
+	amount := Amount{Fen: fen, Currency: currency}
+	return amount, checkAmount(amount)
 }
 
-// 查询所有票据信息 Search all the bill infos
-func (s *SmartContract) QueryAllBill(ctx contractapi.TransactionContextInterface) ([]Bill, error) {
-	// 根据范围查询，查询系统中所有的票据信息
-	// Set the search rage by StartKey and endKey to distinguish among personal infos and bill infos
-	startKey := ""
-	endKey := ""
-	resultsIterator, err := ctx.GetStub().GetStateByRange(startKey, endKey)
-	if err != nil {
-		return nil, err
+// 将十进制金额字符串转换为分，最多两位小数
+// Convert a decimal amount with at most two places to fen
+func parseFen(value string) (int64, error) {
+	if !amountPattern.MatchString(value) {
+		return 0, &InvalidArgumentError{Name: "amount", Value: value}
 	}
-	defer resultsIterator.Close()
-	// 声明Bill类型结构体数组，接收返回数据
-	var results []Bill
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
-		var bill Bill
-		err = json.Unmarshal(queryResponse.Value, &bill)
-		if err != nil {
-			return nil, err
-		}
-		results = append(results, bill)
+	parts := strings.SplitN(value, ".", 2)
+	yuan, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || yuan > MaxAmountFen/100 {
+		return 0, &InvalidArgumentError{Name: "amount", Value: value}
 	}
-
-	return results, nil
+	fen := yuan * 100
+	if len(parts) == 2 {
+		cents, _ := strconv.ParseInt((parts[1] + "0")[:2], 10, 64)
+		fen += cents
+	}
+	return fen, nil
 }
 
-// 票据发布 Issue Bill function
-// args: 0 - {Bill Object}
-func (s *SmartContract) IssueBill(ctx contractapi.TransactionContextInterface, billInfoID string, billInfoMoney string, billInfoType string, billInfoIssueDate string, billInfoDueDate string, pubBillID string, pubBillName string, payBillID string, payBillName string, acceptBillID string, acceptBillName string, holdBillID string, holdBillName string) error {
-
-	//将参数包装为Bill结构体类型
-	// Receive the parameters and fill into a bill object
-	// Convert the object to json and store to the BlockChain Network
-	bill := Bill{
-		BillInfoID:        billInfoID,
-		BillInfoMoney:     billInfoMoney,
-		BillInfoType:      billInfoType,
-		BillInfoIssueDate: billInfoIssueDate,
-		BillInfoDueDate:   billInfoDueDate,
-		PubBillID:         pubBillID,
-		PubBillName:       pubBillName,
-		PayBillID:         payBillID,
-		PayBillName:       payBillName,
-		AcceptBillID:      acceptBillID,
-		AcceptBillName:    acceptBillName,
-		HoldBillID:        holdBillID,
-		HoldBillName:      holdBillName,
-		EndorsedID:        "",
-		EndorsedName:      "",
-		Message:           "",
-		State:             "made",
+// 校验金额为正数、不超过上限且币种代码有效
+// Check the amount is positive, within the limit and has a valid currency code
+func checkAmount(amount Amount) error {
+	if amount.Invalid != "" {
+		return &InvalidArgumentError{Name: "amount", Value: amount.Invalid}
+	}
+	if amount.Fen <= 0 || amount.Fen > MaxAmountFen {
+		return &InvalidArgumentError{Name: "amount", Value: amount.String()}
 	}
-	//结构体转json
-	// Convert from struct to json
-	billAsBytes, _ := json.Marshal(bill)
-	// 以票据编号作为key值，将票据信息存入区块链中
-	// store bill (BillInfoID as the primary key)
-	return ctx.GetStub().PutState(bill.BillInfoID, billAsBytes)
+	if !currencyPattern.MatchString(amount.Currency) {
+		return &InvalidArgumentError{Name: "currency", Value: amount.Currency}
+	}
+	return nil
 }
 
-// 同意承兑 Agree to pay Function, update the state and messgae of the bill
-func (s *SmartContract) AgreePayBill(ctx contractapi.TransactionContextInterface, billInfoID string, billInfoMoney string, billInfoType string, billInfoIssueDate string, billInfoDueDate string, pubBillID string, pubBillName string, payBillID string, payBillName string, acceptBillID string, acceptBillName string, holdBillID string, holdBillName string) error {
+// 票据搜索条件，各条件均为可选，同时给出时取交集
+// Bill search filters. Every filter is optional, the given ones must all match
+type BillSearch struct {
+	States        []string `json:"states"`        // 票据状态之一  One of these states
+	PartyID       string   `json:"partyId"`       // 任一当事人为该公司  Any party of the bill is this company
+	BillType      string   `json:"billType"`      // 票据类型  Bill type
+	Currency      string   `json:"currency"`      // 币种  Currency
+	MinAmount     string   `json:"minAmount"`     // 最小金额  Lowest amount
+	MaxAmount     string   `json:"maxAmount"`     // 最大金额  Highest amount
+	IssueDateFrom string   `json:"issueDateFrom"` // 出票日期起  Issued on or after
+	IssueDateTo   string   `json:"issueDateTo"`   // 出票日期止  Issued on or before
+	DueDateFrom   string   `json:"dueDateFrom"`   // 到期日期起  Due on or after
+	DueDateTo     string   `json:"dueDateTo"`     // 到期日期止  Due on or before
+	IDPrefix      string   `json:"idPrefix"`      // 票据编号前缀  Bill ID prefix
+	Transferable  *bool    `json:"transferable"`  // 是否可以转让  Whether the bill can be transferred
+	SortBy        string   `json:"sortBy"`        // 排序字段  Field to sort on
+	SortOrder     string   `json:"sortOrder"`     // asc / desc
+}
 
-	bill := Bill{
-		BillInfoID:        billInfoID,
-		BillInfoMoney:     billInfoMoney,
-		BillInfoType:      billInfoType,
-		BillInfoIssueDate: billInfoIssueDate,
-		BillInfoDueDate:   billInfoDueDate,
-		PubBillID:         pubBillID,
-		PubBillName:       pubBillName,
-		PayBillID:         payBillID,
-		PayBillName:       payBillName,
-		AcceptBillID:      acceptBillID,
-		AcceptBillName:    acceptBillName,
-		HoldBillID:        holdBillID,
-		HoldBillName:      holdBillName,
-		EndorsedID:        "",
-		EndorsedName:      "",
-		Message:           "waitpaysuccess",
-		State:             "public",
-	}
-	//结构体转json
-	// Convert from struct to json
-	billAsBytes, _ := json.Marshal(bill)
+// 公司登记信息，公开数据，不包含任何登录凭证（用户名和密码由后端链下保存）
+// Company registry record, public data only. Login credentials are kept off-chain by the backend
+type Company struct {
+	DocType     string `json:"docType"`     // 数据类型，固定为 "company"
+	CompanyId   string `json:"CompanyId"`   // 公司ID
+	CompanyName string `json:"CompanyName"` // 公司名称
+	Role        string `json:"Role"`        // 角色 bank / company / admin
+	MSPID       string `json:"MSPID"`       // 签发该公司证书的MSP  The MSP issuing the company's certificates
+}
 
-	return ctx.GetStub().PutState(bill.BillInfoID, billAsBytes)
+// 票据状态转换规则，key 为当前状态，value 为允许到达的状态
+// The legal moves of the bill state machine, current state -> reachable states
+var billStateTransitions = map[string][]string{
+	BillInfo_State_Made:           {BillInfo_State_Public, BillInfo_State_BillFail},
+	BillInfo_State_Public:         {BillInfo_State_EnWaitSign, BillInfo_State_DcWaitSigned, BillInfo_State_Matured, BillInfo_State_Overdue},
+	BillInfo_State_EnWaitSign:     {BillInfo_State_Public},
+	BillInfo_State_DcWaitSigned:   {BillInfo_State_Public},
+	BillInfo_State_Matured:        {BillInfo_State_Overdue, BillInfo_State_Presented},
+	BillInfo_State_Overdue:        {BillInfo_State_Presented, BillInfo_State_Recourse},
+	BillInfo_State_Presented:      {BillInfo_State_Settled, BillInfo_State_Dishonored},
+	BillInfo_State_Dishonored:     {BillInfo_State_Recourse},
+	BillInfo_State_Recourse:       {BillInfo_State_RecourseAgreed, BillInfo_State_Dishonored, BillInfo_State_Overdue},
+	BillInfo_State_RecourseAgreed: {BillInfo_State_Settled},
 }
 
-// 拒绝承兑 Disagree to pay Function, update all the info except billID of the bill
-func (s *SmartContract) DisagreePayBill(ctx contractapi.TransactionContextInterface, billInfoID string) error {
+// 票据编号及公司ID的格式，只允许字母、数字、下划线和横线；金额最多两位小数；币种为三位大写字母；MSP ID 另可包含点
+// Formats of bill IDs and company IDs (letters, digits, underscores and dashes only), of amounts
+// (at most two decimal places), of currency codes (three capital letters) and of MSP IDs (dots allowed too)
+var (
+	billIDPattern   = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+	partyIDPattern  = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+	amountPattern   = regexp.MustCompile(`^[0-9]+(\.[0-9]{1,2})?$`)
+	currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+	mspIDPattern    = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+)
 
-	bill := Bill{
-		BillInfoID:        billInfoID,
-		BillInfoMoney:     "",
-		BillInfoType:      "",
-		BillInfoIssueDate: "",
-		BillInfoDueDate:   "",
-		PubBillID:         "",
-		PubBillName:       "",
-		PayBillID:         "",
-		PayBillName:       "",
-		AcceptBillID:      "",
-		AcceptBillName:    "",
-		HoldBillID:        "",
-		HoldBillName:      "",
-		EndorsedID:        "",
-		EndorsedName:      "",
-		Message:           "waitpayfail",
-		State:             "billfail",
-	}
-	//结构体转json
-	billAsBytes, _ := json.Marshal(bill)
+// 全部票据状态
+// All the bill states
+var billStates = []string{
+	BillInfo_State_Made,
+	BillInfo_State_Public,
+	BillInfo_State_EnWaitSign,
+	BillInfo_State_DcWaitSigned,
+	BillInfo_State_WaitPay,
+	BillInfo_State_BillFail,
+	BillInfo_State_Matured,
+	BillInfo_State_Overdue,
+	BillInfo_State_Presented,
+	BillInfo_State_Settled,
+	BillInfo_State_Dishonored,
+	BillInfo_State_Recourse,
+	BillInfo_State_RecourseAgreed,
+}
 
-	return ctx.GetStub().PutState(bill.BillInfoID, billAsBytes)
+// 票据不存在错误
+// BillNotFoundError is returned when no bill is stored under the given ID
+type BillNotFoundError struct {
+	BillInfoID string
 }
 
-// 申请贴现	Apply to discount function (change the state of the bill)
-func (s *SmartContract) DiscountBill(ctx contractapi.TransactionContextInterface, billInfoID string, billInfoMoney string, billInfoType string, billInfoIssueDate string, billInfoDueDate string, pubBillID string, pubBillName string, payBillID string, payBillName string, acceptBillID string, acceptBillName string, holdBillID string, holdBillName string) error {
+func (e *BillNotFoundError) Error() string {
+	return fmt.Sprintf("bill %s does not exist", e.BillInfoID)
+}
 
-	bill := Bill{
-		BillInfoID:        billInfoID,
-		BillInfoMoney:     billInfoMoney,
-		BillInfoType:      billInfoType,
-		BillInfoIssueDate: billInfoIssueDate,
-		BillInfoDueDate:   billInfoDueDate,
-		PubBillID:         pubBillID,
-		PubBillName:       pubBillName,
-		PayBillID:         payBillID,
-		PayBillName:       payBillName,
-		AcceptBillID:      acceptBillID,
-		AcceptBillName:    acceptBillName,
-		HoldBillID:        holdBillID,
-		HoldBillName:      holdBillName,
-		EndorsedID:        "",
-		EndorsedName:      "",
-		Message:           "",
-		State:             "dcwaitsigned",
-	}
-	//结构体转json
-	billAsBytes, _ := json.Marshal(bill)
+// 参数格式错误
+// InvalidArgumentError is returned when an ID argument is malformed
+type InvalidArgumentError struct {
+	Name  string
+	Value string
+}
 
-	return ctx.GetStub().PutState(bill.BillInfoID, billAsBytes)
+func (e *InvalidArgumentError) Error() string {
+	return fmt.Sprintf("invalid argument: %s %q", e.Name, e.Value)
 }
 
-// 同意贴现
-// Agree to discount,
-// 1. Change the bill state to Public
-// 2. Change the bill message to DiscountSuccess
-// 3. Change the bill's pay user info
-func (s *SmartContract) AgreeDiscountBill(ctx contractapi.TransactionContextInterface, billInfoID string, billInfoMoney string, billInfoType string, billInfoIssueDate string, billInfoDueDate string, pubBillID string, pubBillName string, payBillID string, payBillName string, acceptBillID string, acceptBillName string, holdBillID string, holdBillName string) error {
+// 票据不得转让错误
+// NotTransferableError is returned when a bill marked "not transferable" is endorsed or discounted
+type NotTransferableError struct {
+	BillInfoID string
+}
 
-	bill := Bill{
-		BillInfoID:        billInfoID,
-		BillInfoMoney:     billInfoMoney,
-		BillInfoType:      billInfoType,
-		BillInfoIssueDate: billInfoIssueDate,
-		BillInfoDueDate:   billInfoDueDate,
-		PubBillID:         pubBillID,
-		PubBillName:       pubBillName,
-		PayBillID:         payBillID,
-		PayBillName:       payBillName,
-		AcceptBillID:      acceptBillID,
-		AcceptBillName:    acceptBillName,
-		HoldBillID:        holdBillID,
-		HoldBillName:      holdBillName,
-		EndorsedID:        "",
-		EndorsedName:      "",
-		Message:           "discountsuccess",
-		State:             "public",
-	}
-	//结构体转json
-	billAsBytes, _ := json.Marshal(bill)
+func (e *NotTransferableError) Error() string {
+	return fmt.Sprintf("bill %s is not transferable", e.BillInfoID)
+}
 
-	return ctx.GetStub().PutState(bill.BillInfoID, billAsBytes)
+// 票据已到期错误，到期的票据不能再背书或贴现
+// BillDueError is returned when a bill past its due date is endorsed or discounted
+type BillDueError struct {
+	BillInfoID string
+	DueDate    string
 }
 
-// 拒绝贴现
-// Disagree to discont
-// 1. Change the bill state to Public
-// 2. Change the bill message to DiscountFail
-func (s *SmartContract) ADisagreeDiscountBill(ctx contractapi.TransactionContextInterface, billInfoID string, billInfoMoney string, billInfoType string, billInfoIssueDate string, billInfoDueDate string, pubBillID string, pubBillName string, payBillID string, payBillName string, acceptBillID string, acceptBillName string, holdBillID string, holdBillName string) error {
+func (e *BillDueError) Error() string {
+	return fmt.Sprintf("bill %s is due since %s", e.BillInfoID, e.DueDate)
+}
 
-	bill := Bill{
-		BillInfoID:        billInfoID,
-		BillInfoMoney:     billInfoMoney,
-		BillInfoType:      billInfoType,
-		BillInfoIssueDate: billInfoIssueDate,
-		BillInfoDueDate:   billInfoDueDate,
-		PubBillID:         pubBillID,
-		PubBillName:       pubBillName,
-		PayBillID:         payBillID,
-		PayBillName:       payBillName,
-		AcceptBillID:      acceptBillID,
-		AcceptBillName:    acceptBillName,
-		HoldBillID:        holdBillID,
-		HoldBillName:      holdBillName,
-		EndorsedID:        "",
-		EndorsedName:      "",
-		Message:           "discountfail",
-		State:             "public",
-	}
-	//结构体转json
-	billAsBytes, _ := json.Marshal(bill)
+// 非法的票据状态转换错误
+// IllegalStateError is returned when a transaction tries an illegal move of the bill state machine
+type IllegalStateError struct {
+	BillInfoID string
+	From       string
+	To         string
+}
 
-	return ctx.GetStub().PutState(bill.BillInfoID, billAsBytes)
+func (e *IllegalStateError) Error() string {
+	return fmt.Sprintf("illegal state transition: bill %s cannot move from %q to %q", e.BillInfoID, e.From, e.To)
 }
 
-// 申请背书
-// Apply to endorse, add bill's EndorsedID、EndorsedName infos and update the state to EnWaitSign
-func (s *SmartContract) EndorseBill(ctx contractapi.TransactionContextInterface, billInfoID string, billInfoMoney string, billInfoType string, billInfoIssueDate string, billInfoDueDate string, pubBillID string, pubBillName string, payBillID string, payBillName string, acceptBillID string, acceptBillName string, holdBillID string, holdBillName string, endorsedID string, endorsedName string) error {
+// 无权限错误
+// UnauthorizedError is returned when the caller is not allowed to perform the transaction
+type UnauthorizedError struct {
+	MSPID     string
+	CompanyID string
+	Action    string
+}
 
-	bill := Bill{
-		BillInfoID:        billInfoID,
-		BillInfoMoney:     billInfoMoney,
-		BillInfoType:      billInfoType,
-		BillInfoIssueDate: billInfoIssueDate,
-		BillInfoDueDate:   billInfoDueDate,
-		PubBillID:         pubBillID,
-		PubBillName:       pubBillName,
-		PayBillID:         payBillID,
-		PayBillName:       payBillName,
-		AcceptBillID:      acceptBillID,
-		AcceptBillName:    acceptBillName,
-		HoldBillID:        holdBillID,
-		HoldBillName:      holdBillName,
-		EndorsedID:        endorsedID,
-		EndorsedName:      endorsedName,
-		Message:           "",
-		State:             "enwaitsign",
-	}
-	//结构体转json
-	billAsBytes, _ := json.Marshal(bill)
+func (e *UnauthorizedError) Error() string {
+	return fmt.Sprintf("unauthorized: company %q of %s is not allowed to %s", e.CompanyID, e.MSPID, e.Action)
+}
 
-	return ctx.GetStub().PutState(bill.BillInfoID, billAsBytes)
+// 交易调用者身份
+// Caller is the identity which invokes the transaction
+type Caller struct {
+	MSPID       string
+	CompanyID   string
+	CompanyName string
+	Role        string
 }
 
-// 同意背书
-// Agree to endorse, update the bill's pay user info
-func (s *SmartContract) AgreeEndorseBill(ctx contractapi.TransactionContextInterface, billInfoID string, billInfoMoney string, billInfoType string, billInfoIssueDate string, billInfoDueDate string, pubBillID string, pubBillName string, payBillID string, payBillName string, acceptBillID string, acceptBillName string, holdBillID string, holdBillName string, endorsedID string, endorsedName string) error {
+// 从客户端证书中读取调用者身份，并校验其与公司登记信息一致
+// Read the caller's identity from the client certificate and check it against the company registry
+func getCaller(ctx contractapi.TransactionContextInterface) (*Caller, error) {
+	caller, err := readCaller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = checkRegistered(ctx, caller)
+	if err != nil {
+		return nil, err
+	}
+	return caller, nil
+}
 
-	bill := Bill{
-		BillInfoID:        billInfoID,
-		BillInfoMoney:     billInfoMoney,
-		BillInfoType:      billInfoType,
-		BillInfoIssueDate: billInfoIssueDate,
-		BillInfoDueDate:   billInfoDueDate,
-		PubBillID:         pubBillID,
+// 从客户端证书中读取调用者身份，未经登记信息校验
+// Read the caller's identity from the client certificate, unchecked against the registry
+func readCaller(ctx contractapi.TransactionContextInterface) (*Caller, error) {
+	identity := ctx.GetClientIdentity()
+	mspID, err := identity.GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get caller's MSP ID. %s", err.Error())
+	}
+	companyID, _, err := identity.GetAttributeValue(Identity_Attr_CompanyID)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get caller's company ID. %s", err.Error())
+	}
+	companyName, _, err := identity.GetAttributeValue(Identity_Attr_CompanyName)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get caller's company name. %s", err.Error())
+	}
+	role, _, err := identity.GetAttributeValue(Identity_Attr_Role)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get caller's role. %s", err.Error())
+	}
+	return &Caller{MSPID: mspID, CompanyID: companyID, CompanyName: companyName, Role: role}, nil
+}
+
+// 校验调用者证书中的公司ID和角色已登记在其所属的MSP下。任何组织的CA都能签发带有
+// companyId=acmid 或 role=bank 属性的证书，只有与登记信息一致的证书才被承认
+// Check the company ID and role of the caller's certificate are registered under its MSP. Any
+// organization's CA can issue a certificate claiming companyId=acmid or role=bank, only the one
+// matching the registry is honoured
+func checkRegistered(ctx contractapi.TransactionContextInterface, caller *Caller) error {
+	unregistered := &UnauthorizedError{MSPID: caller.MSPID, CompanyID: caller.CompanyID, Action: fmt.Sprintf("act as %q without a matching company registration", caller.Role)}
+	if caller.CompanyID == "" {
+		return unregistered
+	}
+	company, err := getCompany(ctx, caller.CompanyID)
+	if err != nil {
+		return err
+	}
+	if company == nil || company.MSPID != caller.MSPID || company.Role != caller.Role {
+		return unregistered
+	}
+	return nil
+}
+
+// 校验调用者是票据中的指定当事人
+// Check the caller is the given party of the bill
+func requireParty(caller *Caller, partyID string, action string) error {
+	if partyID == "" || caller.CompanyID != partyID {
+		return &UnauthorizedError{MSPID: caller.MSPID, CompanyID: caller.CompanyID, Action: action}
+	}
+	return nil
+}
+
+// 校验调用者拥有指定角色之一
+// Check the caller has one of the given roles
+func requireRole(caller *Caller, action string, roles ...string) error {
+	for _, role := range roles {
+		if caller.Role == role {
+			return nil
+		}
+	}
+	return &UnauthorizedError{MSPID: caller.MSPID, CompanyID: caller.CompanyID, Action: action}
+}
+
+// 读取调用者身份，并校验其为票据中的指定当事人
+// Load the caller and check it is the given party of the bill
+func authorizeParty(ctx contractapi.TransactionContextInterface, partyID string, action string) error {
+	caller, err := getCaller(ctx)
+	if err != nil {
+		return err
+	}
+	return requireParty(caller, partyID, action)
+}
+
+// 读取调用者身份，并校验其角色
+// Load the caller and check its role
+func authorizeRole(ctx contractapi.TransactionContextInterface, action string, roles ...string) error {
+	caller, err := getCaller(ctx)
+	if err != nil {
+		return err
+	}
+	return requireRole(caller, action, roles...)
+}
+
+// 校验票据编号的格式
+// Check the format of a bill ID
+func checkBillID(billInfoID string) error {
+	if !billIDPattern.MatchString(billInfoID) {
+		return &InvalidArgumentError{Name: "bill ID", Value: billInfoID}
+	}
+	return nil
+}
+
+// 校验公司ID的格式
+// Check the format of a company ID
+func checkPartyID(partyID string) error {
+	if !partyIDPattern.MatchString(partyID) {
+		return &InvalidArgumentError{Name: "company ID", Value: partyID}
+	}
+	return nil
+}
+
+// 调用者是否可以查看所有票据，只有银行和管理员可以；其他调用者（包括证书中没有角色的）只能查看自己的票据
+// Whether the caller may read every bill. Only banks and admins may, anyone else (including a
+// certificate without a role) only reads its own bills
+func canReadAllBills(caller *Caller) bool {
+	return caller.Role == Identity_Role_Bank || caller.Role == Identity_Role_Admin
+}
+
+// 校验按当事人查询的公司ID，银行和管理员以外只能查询自己的票据
+// Check the company ID of a per-party query. Anyone but banks and admins can only query their own bills
+func authorizePartyQuery(ctx contractapi.TransactionContextInterface, partyID string) error {
+	err := checkPartyID(partyID)
+	if err != nil {
+		return err
+	}
+	caller, err := getCaller(ctx)
+	if err != nil {
+		return err
+	}
+	if canReadAllBills(caller) {
+		return nil
+	}
+	return requireParty(caller, partyID, "query the bills of "+partyID)
+}
+
+// 校验调用者可以查询所有票据
+// Check the caller may query every bill
+func authorizeAllBillsQuery(ctx contractapi.TransactionContextInterface, action string) error {
+	return authorizeRole(ctx, action, Identity_Role_Bank, Identity_Role_Admin)
+}
+
+// 票据中记载当事人的字段，查看票据的权限及按当事人搜索都由此得出
+// The bill fields naming a party, both the read permission and the party search are built from them
+var billPartyFields = []struct {
+	field string
+	id    func(bill *Bill) string
+}{
+	{"PubBillID", func(bill *Bill) string { return bill.PubBillID }},
+	{"PayBillID", func(bill *Bill) string { return bill.PayBillID }},
+	{"AcceptBillID", func(bill *Bill) string { return bill.AcceptBillID }},
+	{"HoldBillID", func(bill *Bill) string { return bill.HoldBillID }},
+	{"EndorsedID", func(bill *Bill) string { return bill.EndorsedID }},
+	{"RecourseID", func(bill *Bill) string { return bill.RecourseID }},
+}
+
+// 背书记录中记载当事人的字段
+// The endorsement fields naming a party
+var endorsementPartyFields = []struct {
+	field string
+	id    func(endorsement *Endorsement) string
+}{
+	{"endorserId", func(endorsement *Endorsement) string { return endorsement.EndorserID }},
+	{"endorseeId", func(endorsement *Endorsement) string { return endorsement.EndorseeID }},
+}
+
+// 票据的全部当事人，包括背书记录中的各背书人和被背书人
+// Every party of the bill, including the endorsers and endorsees of its endorsement chain
+func billParties(bill *Bill) []string {
+	var parties []string
+	for _, party := range billPartyFields {
+		parties = append(parties, party.id(bill))
+	}
+	for i := range bill.Endorsements {
+		for _, party := range endorsementPartyFields {
+			parties = append(parties, party.id(&bill.Endorsements[i]))
+		}
+	}
+	return parties
+}
+
+// 校验调用者可以查看该票据：银行、管理员或票据的当事人
+// Check the caller may read the bill: a bank, an admin or a party of the bill
+func authorizeBillRead(ctx contractapi.TransactionContextInterface, bill *Bill) error {
+	return authorizeBillParty(ctx, bill, "read bill "+bill.BillInfoID)
+}
+
+// 校验调用者是银行、管理员或票据的当事人
+// Check the caller is a bank, an admin or a party of the bill
+func authorizeBillParty(ctx contractapi.TransactionContextInterface, bill *Bill, action string) error {
+	caller, err := getCaller(ctx)
+	if err != nil {
+		return err
+	}
+	if canReadAllBills(caller) || (caller.CompanyID != "" && containsString(billParties(bill), caller.CompanyID)) {
+		return nil
+	}
+	return &UnauthorizedError{MSPID: caller.MSPID, CompanyID: caller.CompanyID, Action: action}
+}
+
+// 交易时间，取自交易提案的时间戳，所有背书节点一致
+// The transaction time, taken from the proposal timestamp so every endorser agrees on it
+func txTime(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+	ts, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).In(billLocation), nil
+}
+
+// 格式化时间戳
+// Format a timestamp
+func formatTimestamp(t time.Time) string {
+	return t.UTC().Format(TimestampLayout)
+}
+
+// 解析票据日期
+// Parse a bill date
+func parseDate(name string, value string) (time.Time, error) {
+	date, err := time.ParseInLocation(DateLayout, value, billLocation)
+	if err != nil {
+		return time.Time{}, &InvalidArgumentError{Name: name, Value: value}
+	}
+	return date, nil
+}
+
+// 校验出票日期和到期日期：出票日期不晚于交易日，到期日期晚于出票日期和交易日，且不超过最长期限
+// Check the issue and due dates: issued no later than the transaction day, due after both
+// the issue date and the transaction day, and within the longest term
+func checkBillDates(issueDate string, dueDate string, now time.Time) error {
+	issue, err := parseDate("issue date", issueDate)
+	if err != nil {
+		return err
+	}
+	due, err := parseDate("due date", dueDate)
+	if err != nil {
+		return err
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, billLocation)
+	if issue.After(today) {
+		return &InvalidArgumentError{Name: "issue date in the future", Value: issueDate}
+	}
+	if !due.After(issue) || !due.After(today) {
+		return &InvalidArgumentError{Name: "due date not after the issue date and today", Value: dueDate}
+	}
+	if due.After(issue.AddDate(0, MaxBillTermMonths, 0)) {
+		return &InvalidArgumentError{Name: fmt.Sprintf("due date beyond the %d month term", MaxBillTermMonths), Value: dueDate}
+	}
+	return nil
+}
+
+// 按交易时间计算票据应处的到期状态，未到期时返回空字符串
+// The maturity state the bill should be in at the given time, empty while it is not due yet
+func maturityState(bill *Bill, now time.Time) (string, error) {
+	due, err := parseDate("due date", bill.BillInfoDueDate)
+	if err != nil {
+		return "", err
+	}
+	if now.Before(due) {
+		return "", nil
+	}
+	if now.Before(due.AddDate(0, 0, PresentmentPeriodDays+1)) {
+		return BillInfo_State_Matured, nil
+	}
+	return BillInfo_State_Overdue, nil
+}
+
+// 校验票据尚未到期，到期的票据不能背书或贴现
+// Check the bill is not due yet, a due bill can't be endorsed or discounted
+func checkNotDue(ctx contractapi.TransactionContextInterface, bill *Bill) error {
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	state, err := maturityState(bill, now)
+	if err != nil {
+		return err
+	}
+	if state != "" {
+		return &BillDueError{BillInfoID: bill.BillInfoID, DueDate: bill.BillInfoDueDate}
+	}
+	return nil
+}
+
+// 检查票据可以转让，即未被出票人或背书人记载"不得转让"
+// Check the bill can be transferred, i.e. neither the drawer nor an endorser marked it "not transferable"
+func checkTransferable(bill *Bill) error {
+	if bill.NonTransferable {
+		return &NotTransferableError{BillInfoID: bill.BillInfoID}
+	}
+	return nil
+}
+
+// 判断状态转换是否合法
+// Check if the state machine allows moving from one state to another
+func canTransit(from string, to string) bool {
+	for _, state := range billStateTransitions[from] {
+		if state == to {
+			return true
+		}
+	}
+	return false
+}
+
+// 校验票据的当前状态为 from，且允许转换到 to
+// Check the bill is currently in state "from" and may move to state "to"
+func checkTransition(bill *Bill, from string, to string) error {
+	if bill.State != from || !canTransit(from, to) {
+		return &IllegalStateError{BillInfoID: bill.BillInfoID, From: bill.State, To: to}
+	}
+	return nil
+}
+
+// 票据的组合键 bill~BillInfoID
+// The composite key of a bill
+func billKey(ctx contractapi.TransactionContextInterface, billInfoID string) (string, error) {
+	err := checkBillID(billInfoID)
+	if err != nil {
+		return "", err
+	}
+	return ctx.GetStub().CreateCompositeKey(DocType_Bill, []string{billInfoID})
+}
+
+// 公司的组合键 company~CompanyId
+// The composite key of a company
+func companyKey(ctx contractapi.TransactionContextInterface, companyID string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(DocType_Company, []string{companyID})
+}
+
+// 从账本中读取公司登记信息，未登记时返回 nil
+// Load a company registry record, nil when the company is not registered
+func getCompany(ctx contractapi.TransactionContextInterface, companyID string) (*Company, error) {
+	key, err := companyKey(ctx, companyID)
+	if err != nil {
+		return nil, err
+	}
+	companyAsBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+	if companyAsBytes == nil {
+		return nil, nil
+	}
+	company := new(Company)
+	err = json.Unmarshal(companyAsBytes, company)
+	if err != nil {
+		return nil, err
+	}
+	return company, nil
+}
+
+// 将公司登记信息写入账本
+// Store a company registry record to the world state
+func putCompany(ctx contractapi.TransactionContextInterface, company *Company) error {
+	key, err := companyKey(ctx, company.CompanyId)
+	if err != nil {
+		return err
+	}
+	company.DocType = DocType_Company
+	companyAsBytes, err := json.Marshal(company)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, companyAsBytes)
+}
+
+// 公司登记信息是否已绑定MSP。旧版本链码写入的登记信息没有MSP，首次初始化时补充
+// Whether the company registry is bound to MSPs. Records written by the old chaincode
+// carry no MSP, it is filled in by the first initialization
+func registryBound(ctx contractapi.TransactionContextInterface) (bool, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(DocType_Company, []string{})
+	if err != nil {
+		return false, err
+	}
+	defer resultsIterator.Close()
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return false, err
+		}
+		var company Company
+		err = json.Unmarshal(queryResponse.Value, &company)
+		if err != nil {
+			return false, err
+		}
+		if company.MSPID != "" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// 从账本中读取票据的当前状态
+// Load the current bill from the world state
+func getBill(ctx contractapi.TransactionContextInterface, billInfoID string) (*Bill, error) {
+	key, err := billKey(ctx, billInfoID)
+	if err != nil {
+		return nil, err
+	}
+	billAsBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+	if billAsBytes == nil {
+		return nil, &BillNotFoundError{BillInfoID: billInfoID}
+	}
+	bill := new(Bill)
+	err = json.Unmarshal(billAsBytes, bill)
+	if err != nil {
+		return nil, err
+	}
+	return bill, nil
+}
+
+// 将票据写回账本，并返回写入的票据
+// Store the bill to the world state, the stored bill is returned to the caller
+func putBill(ctx contractapi.TransactionContextInterface, bill *Bill) (*Bill, error) {
+	caller, err := getCaller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return writeBill(ctx, caller, bill)
+}
+
+// 以已校验的调用者身份写入票据
+// Store the bill on behalf of an already authorized caller
+func writeBill(ctx contractapi.TransactionContextInterface, caller *Caller, bill *Bill) (*Bill, error) {
+	key, err := billKey(ctx, bill.BillInfoID)
+	if err != nil {
+		return nil, err
+	}
+	// 每次写入都校验金额，作废的票据除外（其信息已被清除）
+	// Every write checks the amount, except for failed bills whose infos were wiped
+	if bill.State != BillInfo_State_BillFail {
+		err = checkAmount(bill.BillInfoMoney)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// 记录最近一次修改票据的调用者，供历史记录显示
+	// Record the invoker on the bill, so the history shows who made each change
+	bill.DocType = DocType_Bill
+	bill.UpdatedBy = caller.CompanyID
+	bill.UpdatedByMSP = caller.MSPID
+	billAsBytes, err := json.Marshal(bill)
+	if err != nil {
+		return nil, err
+	}
+	// 读取写入前的票据，用于删除过期的索引
+	// Load the bill as it was before this write, to drop its stale index keys
+	previous, err := getBill(ctx, bill.BillInfoID)
+	switch err.(type) {
+	case nil:
+	case *BillNotFoundError:
+		previous = nil
+	default:
+		return nil, err
+	}
+	err = ctx.GetStub().PutState(key, billAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	err = putBillIndexes(ctx, previous, bill)
+	if err != nil {
+		return nil, err
+	}
+	return bill, nil
+}
+
+// 写入金额无法识别的旧票据：原值保留在 BillInfoMoney.invalid 中，不写入索引，列表查询中跳过，
+// 只能按编号查看，直到管理员以 CorrectBillAmount 更正金额
+// Store a legacy bill whose amount could not be parsed. The stored value is kept in BillInfoMoney.invalid,
+// no index key is written and the lists skip the bill, it is only read by ID until an admin corrects
+// the amount with CorrectBillAmount
+func writeFlaggedBill(ctx contractapi.TransactionContextInterface, caller *Caller, bill *Bill) error {
+	key, err := billKey(ctx, bill.BillInfoID)
+	if err != nil {
+		return err
+	}
+	bill.DocType = DocType_Bill
+	bill.UpdatedBy = caller.CompanyID
+	bill.UpdatedByMSP = caller.MSPID
+	billAsBytes, err := json.Marshal(bill)
+	if err != nil {
+		return err
+	}
+	// 删除写入前的票据的索引
+	// Drop the index keys of the bill as it was before this write
+	previous, err := getBill(ctx, bill.BillInfoID)
+	switch err.(type) {
+	case nil:
+		staleKeys, err := billIndexKeys(ctx, previous)
+		if err != nil {
+			return err
+		}
+		for _, staleKey := range staleKeys {
+			err = ctx.GetStub().DelState(staleKey)
+			if err != nil {
+				return err
+			}
+		}
+	case *BillNotFoundError:
+	default:
+		return err
+	}
+	return ctx.GetStub().PutState(key, billAsBytes)
+}
+
+// 票据的全部索引组合键
+// All the index composite keys of a bill
+func billIndexKeys(ctx contractapi.TransactionContextInterface, bill *Bill) ([]string, error) {
+	parties := []struct {
+		role string
+		id   string
+	}{
+		{Party_Pub, bill.PubBillID},
+		{Party_Pay, bill.PayBillID},
+		{Party_Accept, bill.AcceptBillID},
+		{Party_Hold, bill.HoldBillID},
+		{Party_Endorsed, bill.EndorsedID},
+		{Party_Recourse, bill.RecourseID},
+	}
+	var keys []string
+	for _, party := range parties {
+		if party.id == "" {
+			continue
+		}
+		key, err := ctx.GetStub().CreateCompositeKey(IndexKey_Party, []string{party.role, party.id, bill.State, bill.BillInfoID})
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	key, err := ctx.GetStub().CreateCompositeKey(IndexKey_State, []string{bill.State, bill.BillInfoID})
+	if err != nil {
+		return nil, err
+	}
+	return append(keys, key), nil
+}
+
+// 更新票据的索引：删除旧票据中不再使用的索引，写入新票据的索引
+// Update the bill's indexes: delete the keys of the previous bill which no longer apply and write the new ones
+func putBillIndexes(ctx contractapi.TransactionContextInterface, previous *Bill, bill *Bill) error {
+	keys, err := billIndexKeys(ctx, bill)
+	if err != nil {
+		return err
+	}
+	current := map[string]bool{}
+	for _, key := range keys {
+		current[key] = true
+	}
+	if previous != nil {
+		staleKeys, err := billIndexKeys(ctx, previous)
+		if err != nil {
+			return err
+		}
+		for _, key := range staleKeys {
+			if current[key] {
+				continue
+			}
+			err = ctx.GetStub().DelState(key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	// 索引的值不需要内容，写入一个空字节
+	// Index keys carry no data, a single null byte is stored
+	for _, key := range keys {
+		err = ctx.GetStub().PutState(key, []byte{0x00})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isExisted(ctx contractapi.TransactionContextInterface, key string) bool {
+	val, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+	}
+	if len(val) == 0 {
+		return false
+	}
+	return true
+}
+
+// InitLedger adds a base set of Bills to the ledger
+// 只有银行或管理员可以初始化。登记信息尚未绑定MSP时（新账本或升级前的账本），只有登记机构 RegistryMSP
+// 的银行或管理员证书可以完成绑定，默认公司登记在该MSP下，与后端在同一组织登记所有公司一致
+// Only banks and admins can initialize. While the registry is not bound to MSPs yet (a new ledger or one
+// from before the upgrade) only a bank or admin certificate of RegistryMSP can bind it: the default companies
+// are registered under that MSP, matching the backend which enrolls every company at one organization
+func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface) error {
+	caller, err := readCaller(ctx)
+	if err != nil {
+		return err
+	}
+	bound, err := registryBound(ctx)
+	if err != nil {
+		return err
+	}
+	if bound {
+		err = checkRegistered(ctx, caller)
+		if err != nil {
+			return err
+		}
+	} else if caller.MSPID != RegistryMSP {
+		return &UnauthorizedError{MSPID: caller.MSPID, CompanyID: caller.CompanyID, Action: "initialize the company registry of " + RegistryMSP}
+	}
+	if err := requireRole(caller, "initialize the ledger", Identity_Role_Admin, Identity_Role_Bank); err != nil {
+		return err
+	}
+
+	// 示例票据以交易日为出票日，分别在三、六、九个月后到期，保证初始化时处于交付状态且在最长期限内
+	// The sample bills are issued on the transaction day and due three, six and nine months later,
+	// so they are "public" and within the longest term when seeded
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	issueDate := now.Format(DateLayout)
+	dueDate := func(months int) string {
+		return now.AddDate(0, months, 0).Format(DateLayout)
+	}
+	bills := []Bill{
+		Bill{BillInfoID: "POA10000998", BillInfoMoney: Amount{Fen: 200000, Currency: DefaultCurrency}, BillInfoType: "A", BillInfoIssueDate: issueDate, BillInfoDueDate: dueDate(3), PubBillID: "bank", PubBillName: "银行", PayBillID: "ccmid", PayBillName: "C公司", AcceptBillID: "acmid", AcceptBillName: "A公司", HoldBillID: "acmid", HoldBillName: "A公司", EndorsedID: "", EndorsedName: "", Message: "", State: "public"},
+		Bill{BillInfoID: "POB10000998", BillInfoMoney: Amount{Fen: 300000, Currency: DefaultCurrency}, BillInfoType: "B", BillInfoIssueDate: issueDate, BillInfoDueDate: dueDate(6), PubBillID: "bank", PubBillName: "银行", PayBillID: "acmid", PayBillName: "A公司", AcceptBillID: "bcmid", AcceptBillName: "B公司", HoldBillID: "bcmid", HoldBillName: "B公司", EndorsedID: "", EndorsedName: "", Message: "", State: "public"},
+		Bill{BillInfoID: "POC10000998", BillInfoMoney: Amount{Fen: 4000000, Currency: DefaultCurrency}, BillInfoType: "C", BillInfoIssueDate: issueDate, BillInfoDueDate: dueDate(9), PubBillID: "bank", PubBillName: "银行", PayBillID: "bmcid", PayBillName: "B公司", AcceptBillID: "ccmid", AcceptBillName: "C公司", HoldBillID: "ccmid", HoldBillName: "C公司", EndorsedID: "", EndorsedName: "", Message: "", State: "public"},
+	}
+
+	// 只写入尚不存在的票据，重复初始化不会覆盖已在流转中的票据
+	// Only the missing bills are written, so initializing again never overwrites bills already in use
+	for i := range bills {
+		key, err := billKey(ctx, bills[i].BillInfoID)
+		if err != nil {
+			return err
+		}
+		if isExisted(ctx, key) {
+			continue
+		}
+		_, err = writeBill(ctx, caller, &bills[i])
+		if err != nil {
+			return fmt.Errorf("Failed to put to init. %s", err.Error())
+		}
+	}
+
+	// 初始化公司登记信息，仅包含公开数据，登录凭证由后端链下保存
+	// Store the public company registry at the beginning of running this system.
+	// The users' credentials are not on the ledger, the backend keeps them off-chain.
+	companies := []Company{
+		Company{CompanyId: "admin", CompanyName: "管理员", Role: Identity_Role_Admin},
+		Company{CompanyId: "bank", CompanyName: "银行", Role: Identity_Role_Bank},
+		Company{CompanyId: "acmid", CompanyName: "A公司", Role: Identity_Role_Company},
+		Company{CompanyId: "bcmid", CompanyName: "B公司", Role: Identity_Role_Company},
+		Company{CompanyId: "ccmid", CompanyName: "C公司", Role: Identity_Role_Company},
+	}
+
+	for i := range companies {
+		// 已登记的公司不再覆盖，旧版本写入的登记信息补充MSP
+		// Registered companies are kept, records from the old chaincode get their MSP
+		current, err := getCompany(ctx, companies[i].CompanyId)
+		if err != nil {
+			return err
+		}
+		if current != nil && current.MSPID != "" {
+			continue
+		}
+		companies[i].MSPID = RegistryMSP
+		err = putCompany(ctx, &companies[i])
+		if err != nil {
+			return fmt.Errorf("Failed to put to init. %s", err.Error())
+		}
+	}
+
+	return deleteLegacySignInfos(ctx)
+}
+
+// 删除旧版本链码写入账本的登录信息：最初以用户名为key明文保存（admin / alice / bob / carle，
+// 密码 123456），其后曾写入 user 组合键下
+// Remove the login infos the old chaincode wrote to the ledger: first in plain text under the
+// bare username (admin / alice / bob / carle, password 123456), later under "user" composite keys
+func deleteLegacySignInfos(ctx contractapi.TransactionContextInterface) error {
+	// 范围查询只返回普通key；用户名与key相同且带有密码的记录即为登录信息
+	// A range query only returns plain keys; a record named after its key and carrying a password is a login info
+	rangeIterator, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return err
+	}
+	defer rangeIterator.Close()
+	for rangeIterator.HasNext() {
+		queryResponse, err := rangeIterator.Next()
+		if err != nil {
+			return err
+		}
+		var signInfo struct {
+			Username string
+			Password string
+		}
+		if json.Unmarshal(queryResponse.Value, &signInfo) != nil || signInfo.Username != queryResponse.Key || signInfo.Password == "" {
+			continue
+		}
+		err = ctx.GetStub().DelState(queryResponse.Key)
+		if err != nil {
+			return err
+		}
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("user", []string{})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		err = ctx.GetStub().DelState(queryResponse.Key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 重建所有票据的索引组合键，并以当前格式重写票据，用于升级前写入的票据。
+// 旧版本链码以票据编号本身为key写入的票据，同时迁移到 bill~票据编号 组合键下。
+// 金额无法识别的票据被标记而不是中止迁移，返回这些票据的编号
+// Rebuild the index composite keys of every bill and rewrite the bill in the current format,
+// for bills written before the upgrade. Bills the old chaincode stored under the bare bill ID
+// are moved to the bill~BillInfoID composite key as well. Bills whose amount can't be parsed
+// are flagged instead of failing the migration, their IDs are returned
+func (s *SmartContract) RebuildBillIndexes(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	caller, err := getCaller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireRole(caller, "rebuild bill indexes", Identity_Role_Admin, Identity_Role_Bank); err != nil {
+		return nil, err
+	}
+	// 删除现有的索引
+	// Drop the existing index keys
+	for _, objectType := range []string{IndexKey_Party, IndexKey_State} {
+		resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(objectType, []string{})
+		if err != nil {
+			return nil, err
+		}
+		for resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				resultsIterator.Close()
+				return nil, err
+			}
+			err = ctx.GetStub().DelState(queryResponse.Key)
+			if err != nil {
+				resultsIterator.Close()
+				return nil, err
+			}
+		}
+		resultsIterator.Close()
+	}
+	// 按每张票据的当前状态重新写入索引
+	// Write the indexes again from the current state of every bill
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(DocType_Bill, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+	bills, err := decodeBills(resultsIterator)
+	if err != nil {
+		return nil, err
+	}
+	flagged := []string{}
+	for i := range bills {
+		if bills[i].BillInfoMoney.Invalid != "" {
+			err = writeFlaggedBill(ctx, caller, &bills[i])
+			if err != nil {
+				return nil, err
+			}
+			flagged = append(flagged, bills[i].BillInfoID)
+			continue
+		}
+		// 以当前格式重写票据，例如将旧版本的字符串金额转换为分；与其他写入一样校验金额并记录调用者
+		// Rewrite the bill in the current format, e.g. legacy string amounts become fen. Like every
+		// other write the amount is checked and the invoker is recorded
+		_, err = writeBill(ctx, caller, &bills[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	migrated, err := migrateLegacyBills(ctx, caller)
+	if err != nil {
+		return nil, err
+	}
+	return append(flagged, migrated...), nil
+}
+
+// 迁移旧版本链码以票据编号为key写入的票据。范围查询只返回普通key，不包括组合键；
+// 组合键下已有同编号票据时（例如重新初始化的示例票据），旧数据已过时，直接删除
+// Move the bills the old chaincode stored under the bare bill ID. A range query only returns
+// plain keys, never composite ones. When a bill with the same ID already exists under its
+// composite key (e.g. a sample bill seeded again) the old copy is stale and only deleted.
+// The IDs of the moved bills whose amount can't be parsed are returned
+func migrateLegacyBills(ctx contractapi.TransactionContextInterface, caller *Caller) ([]string, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+	flagged := []string{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		// 只处理票据，即编号与key相同的记录
+		// Only bills are moved, i.e. records whose ID is their key
+		var bill Bill
+		if json.Unmarshal(queryResponse.Value, &bill) != nil || bill.BillInfoID != queryResponse.Key {
+			continue
+		}
+		key, err := billKey(ctx, bill.BillInfoID)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case isExisted(ctx, key):
+		case bill.BillInfoMoney.Invalid != "":
+			err = writeFlaggedBill(ctx, caller, &bill)
+			if err != nil {
+				return nil, err
+			}
+			flagged = append(flagged, bill.BillInfoID)
+		default:
+			_, err = writeBill(ctx, caller, &bill)
+			if err != nil {
+				return nil, err
+			}
+		}
+		err = ctx.GetStub().DelState(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return flagged, nil
+}
+
+// 更正被标记的旧票据的金额，只有管理员可以调用；金额有效的票据不能修改
+// Correct the amount of a flagged legacy bill. Only admins can call it, a bill with a valid amount can't be changed
+func (s *SmartContract) CorrectBillAmount(ctx contractapi.TransactionContextInterface, billInfoID string, billInfoMoney string, billInfoCurrency string) (*Bill, error) {
+	if err := authorizeRole(ctx, "correct the amount of bill "+billInfoID, Identity_Role_Admin); err != nil {
+		return nil, err
+	}
+	bill, err := getBill(ctx, billInfoID)
+	if err != nil {
+		return nil, err
+	}
+	if bill.BillInfoMoney.Invalid == "" {
+		return nil, &InvalidArgumentError{Name: "bill without an invalid amount", Value: billInfoID}
+	}
+	amount, err := parseAmount(billInfoMoney, billInfoCurrency)
+	if err != nil {
+		return nil, err
+	}
+	bill.BillInfoMoney = amount
+	return putBill(ctx, bill)
+}
+
+// 登记新的公司，只有银行和管理员可以调用；银行及管理员角色只能由管理员登记。已登记的公司不能重复登记
+// Register a new company. Only banks and admins can call it, and only admins can register banks and admins.
+// A registered company cannot be registered again
+func (s *SmartContract) RegisterCompany(ctx contractapi.TransactionContextInterface, companyID string, companyName string, role string, mspID string) (*Company, error) {
+	caller, err := getCaller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireRole(caller, "register company "+companyID, Identity_Role_Admin, Identity_Role_Bank); err != nil {
+		return nil, err
+	}
+	if err := checkPartyID(companyID); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(companyName) == "" {
+		return nil, &InvalidArgumentError{Name: "company name", Value: companyName}
+	}
+	if !containsString([]string{Identity_Role_Bank, Identity_Role_Company, Identity_Role_Admin}, role) {
+		return nil, &InvalidArgumentError{Name: "role", Value: role}
+	}
+	if role != Identity_Role_Company {
+		if err := requireRole(caller, "register "+role+" "+companyID, Identity_Role_Admin); err != nil {
+			return nil, err
+		}
+	}
+	if !mspIDPattern.MatchString(mspID) {
+		return nil, &InvalidArgumentError{Name: "MSP ID", Value: mspID}
+	}
+	current, err := getCompany(ctx, companyID)
+	if err != nil {
+		return nil, err
+	}
+	if current != nil {
+		return nil, &InvalidArgumentError{Name: "company ID already registered", Value: companyID}
+	}
+	company := &Company{CompanyId: companyID, CompanyName: companyName, Role: role, MSPID: mspID}
+	err = putCompany(ctx, company)
+	if err != nil {
+		return nil, err
+	}
+	return company, nil
+}
+
+// 查询所有公司登记信息
+// Query the public company registry
+func (s *SmartContract) QueryAllCompanies(ctx contractapi.TransactionContextInterface) ([]Company, error) {
+	// 根据组合键前缀查询，查询系统中所有的公司信息
+	// Iterate over the "company" composite keys only, so bills never show up here
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(DocType_Company, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+	// 声明Company类型结构体数组，接收返回数据
+	// Receive the infos
+	var results []Company
+	// 迭代上述查询到的结果，处理其中的信息
+	// use Iterator to deal with infos
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var company Company
+		// 将其中一条数据反序列化，存入Company类型变量中
+		// deserialize
+		err = json.Unmarshal(queryResponse.Value, &company)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, company)
+	}
+	// 返回查询到的结果
+	return results, nil
+}
+
+// 查询所有票据信息 Search all the bill infos
+func (s *SmartContract) QueryAllBill(ctx contractapi.TransactionContextInterface) ([]Bill, error) {
+	if err := authorizeAllBillsQuery(ctx, "query all the bills"); err != nil {
+		return nil, err
+	}
+	// 根据组合键前缀查询，查询系统中所有的票据信息
+	// Iterate over the "bill" composite keys only, so users never show up here
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(DocType_Bill, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	return readBills(resultsIterator)
+}
+
+// 票据发布 Issue Bill function
+// args: 0 - {Bill Object}
+// nonTransferable 为 true 时出票人记载"不得转让"
+// When nonTransferable is true the drawer marks the bill "not transferable"
+func (s *SmartContract) IssueBill(ctx contractapi.TransactionContextInterface, billInfoID string, billInfoMoney string, billInfoCurrency string, billInfoType string, billInfoIssueDate string, billInfoDueDate string, pubBillID string, pubBillName string, payBillID string, payBillName string, acceptBillID string, acceptBillName string, holdBillID string, holdBillName string, nonTransferable bool) (*Bill, error) {
+	// 只有银行可以发布票据
+	// Only banks can issue bills
+	if err := authorizeRole(ctx, "issue bill", Identity_Role_Bank); err != nil {
+		return nil, err
+	}
+	for _, partyID := range []string{pubBillID, payBillID, acceptBillID, holdBillID} {
+		if err := checkPartyID(partyID); err != nil {
+			return nil, err
+		}
+	}
+	amount, err := parseAmount(billInfoMoney, billInfoCurrency)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = checkBillDates(billInfoIssueDate, billInfoDueDate, now)
+	if err != nil {
+		return nil, err
+	}
+	// 已存在的票据只有在 made 状态下（尚未承兑）才能重新发布
+	// An existing bill can only be issued again while it is still "made"
+	current, err := getBill(ctx, billInfoID)
+	switch err.(type) {
+	case nil:
+		if current.State != BillInfo_State_Made {
+			return nil, &IllegalStateError{BillInfoID: billInfoID, From: current.State, To: BillInfo_State_Made}
+		}
+	case *BillNotFoundError:
+	default:
+		return nil, err
+	}
+
+	//将参数包装为Bill结构体类型
+	// Receive the parameters and fill into a bill object
+	// Convert the object to json and store to the BlockChain Network
+	bill := &Bill{
+		BillInfoID:        billInfoID,
+		BillInfoMoney:     amount,
+		BillInfoType:      billInfoType,
+		BillInfoIssueDate: billInfoIssueDate,
+		BillInfoDueDate:   billInfoDueDate,
+		NonTransferable:   nonTransferable,
+		PubBillID:         pubBillID,
 		PubBillName:       pubBillName,
 		PayBillID:         payBillID,
 		PayBillName:       payBillName,
-		AcceptBillID:      endorsedID,
-		AcceptBillName:    endorsedName,
-		HoldBillID:        endorsedID,
-		HoldBillName:      endorsedName,
+		AcceptBillID:      acceptBillID,
+		AcceptBillName:    acceptBillName,
+		HoldBillID:        holdBillID,
+		HoldBillName:      holdBillName,
 		EndorsedID:        "",
 		EndorsedName:      "",
-		Message:           "endorsesuccess",
-		State:             "public",
+		Message:           "",
+		State:             BillInfo_State_Made,
+	}
+	// 以 bill~票据编号 组合键为key值，将票据信息存入区块链中
+	// store bill (composite key of BillInfoID as the primary key)
+	return putBill(ctx, bill)
+}
+
+// 同意承兑 Agree to pay Function, update the state and messgae of the bill
+func (s *SmartContract) AgreePayBill(ctx contractapi.TransactionContextInterface, billInfoID string) (*Bill, error) {
+	// 读取票据当前状态，校验状态转换
+	// Load the current bill and validate the transition
+	bill, err := getBill(ctx, billInfoID)
+	if err != nil {
+		return nil, err
+	}
+	// 只有承兑人可以同意承兑
+	// Only the pay user can agree to pay
+	if err := authorizeParty(ctx, bill.PayBillID, "agree to pay bill "+billInfoID); err != nil {
+		return nil, err
+	}
+	if err := checkTransition(bill, BillInfo_State_Made, BillInfo_State_Public); err != nil {
+		return nil, err
+	}
+
+	bill.Message = Message_WaitPaySuccess
+	bill.State = BillInfo_State_Public
+	return putBill(ctx, bill)
+}
+
+// 拒绝承兑 Disagree to pay Function, update all the info except billID of the bill
+func (s *SmartContract) DisagreePayBill(ctx contractapi.TransactionContextInterface, billInfoID string) (*Bill, error) {
+	// 读取票据当前状态，校验状态转换
+	// Load the current bill and validate the transition
+	current, err := getBill(ctx, billInfoID)
+	if err != nil {
+		return nil, err
+	}
+	// 只有承兑人可以拒绝承兑
+	// Only the pay user can refuse to pay
+	if err := authorizeParty(ctx, current.PayBillID, "refuse to pay bill "+billInfoID); err != nil {
+		return nil, err
+	}
+	if err := checkTransition(current, BillInfo_State_Made, BillInfo_State_BillFail); err != nil {
+		return nil, err
+	}
+
+	bill := &Bill{
+		BillInfoID: current.BillInfoID,
+		Message:    Message_WaitPayFail,
+		State:      BillInfo_State_BillFail,
+	}
+	return putBill(ctx, bill)
+}
+
+// 申请贴现	Apply to discount function (change the state of the bill)
+func (s *SmartContract) DiscountBill(ctx contractapi.TransactionContextInterface, billInfoID string) (*Bill, error) {
+	// 读取票据当前状态，校验状态转换
+	// Load the current bill and validate the transition
+	bill, err := getBill(ctx, billInfoID)
+	if err != nil {
+		return nil, err
+	}
+	// 只有持票人可以申请贴现
+	// Only the holder can apply to discount
+	if err := authorizeParty(ctx, bill.HoldBillID, "discount bill "+billInfoID); err != nil {
+		return nil, err
+	}
+	if err := checkTransition(bill, BillInfo_State_Public, BillInfo_State_DcWaitSigned); err != nil {
+		return nil, err
+	}
+	if err := checkNotDue(ctx, bill); err != nil {
+		return nil, err
+	}
+	if err := checkTransferable(bill); err != nil {
+		return nil, err
+	}
+
+	bill.EndorsedID = ""
+	bill.EndorsedName = ""
+	bill.EndorsedRestrictive = false
+	bill.Message = ""
+	bill.State = BillInfo_State_DcWaitSigned
+	return putBill(ctx, bill)
+}
+
+// 同意贴现
+// Agree to discount,
+// 1. Change the bill state to Public
+// 2. Change the bill message to DiscountSuccess
+// 3. Change the bill's accept and hold user to the bank
+func (s *SmartContract) AgreeDiscountBill(ctx contractapi.TransactionContextInterface, billInfoID string) (*Bill, error) {
+	// 读取票据当前状态，校验状态转换
+	// Load the current bill and validate the transition
+	bill, err := getBill(ctx, billInfoID)
+	if err != nil {
+		return nil, err
+	}
+	// 只有银行可以同意贴现
+	// Only banks can agree to discount
+	caller, err := getCaller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireRole(caller, "agree to discount bill "+billInfoID, Identity_Role_Bank); err != nil {
+		return nil, err
+	}
+	if err := checkTransition(bill, BillInfo_State_DcWaitSigned, BillInfo_State_Public); err != nil {
+		return nil, err
+	}
+
+	// 贴现记为向银行的背书，之后银行成为收款人和持票人
+	// The discount is recorded as an endorsement to the bank, which then becomes the accept user and the holder
+	if err := appendEndorsement(ctx, bill, Endorsement_Discount, caller.CompanyID, caller.CompanyName, false); err != nil {
+		return nil, err
+	}
+	bill.AcceptBillID = caller.CompanyID
+	bill.AcceptBillName = caller.CompanyName
+	bill.HoldBillID = caller.CompanyID
+	bill.HoldBillName = caller.CompanyName
+	bill.Message = Message_DcSuccess
+	bill.State = BillInfo_State_Public
+	return putBill(ctx, bill)
+}
+
+// 拒绝贴现
+// Disagree to discont
+// 1. Change the bill state to Public
+// 2. Change the bill message to DiscountFail
+func (s *SmartContract) ADisagreeDiscountBill(ctx contractapi.TransactionContextInterface, billInfoID string) (*Bill, error) {
+	// 读取票据当前状态，校验状态转换
+	// Load the current bill and validate the transition
+	bill, err := getBill(ctx, billInfoID)
+	if err != nil {
+		return nil, err
+	}
+	// 只有银行可以拒绝贴现
+	// Only banks can refuse to discount
+	if err := authorizeRole(ctx, "refuse to discount bill "+billInfoID, Identity_Role_Bank); err != nil {
+		return nil, err
+	}
+	if err := checkTransition(bill, BillInfo_State_DcWaitSigned, BillInfo_State_Public); err != nil {
+		return nil, err
+	}
+
+	bill.Message = Message_DcFail
+	bill.State = BillInfo_State_Public
+	return putBill(ctx, bill)
+}
+
+// 申请背书
+// Apply to endorse, add bill's EndorsedID、EndorsedName infos and update the state to EnWaitSign
+// restrictive 为 true 时背书记载"不得转让"
+// When restrictive is true the endorsement is marked "not transferable"
+func (s *SmartContract) EndorseBill(ctx contractapi.TransactionContextInterface, billInfoID string, endorsedID string, endorsedName string, restrictive bool) (*Bill, error) {
+	// 读取票据当前状态，校验状态转换
+	// Load the current bill and validate the transition
+	bill, err := getBill(ctx, billInfoID)
+	if err != nil {
+		return nil, err
+	}
+	// 只有持票人可以申请背书
+	// Only the holder can apply to endorse
+	if err := authorizeParty(ctx, bill.HoldBillID, "endorse bill "+billInfoID); err != nil {
+		return nil, err
+	}
+	if err := checkTransition(bill, BillInfo_State_Public, BillInfo_State_EnWaitSign); err != nil {
+		return nil, err
+	}
+	if err := checkNotDue(ctx, bill); err != nil {
+		return nil, err
+	}
+	if err := checkTransferable(bill); err != nil {
+		return nil, err
+	}
+	// 不能背书给自己
+	// The holder cannot endorse the bill to itself
+	if err := checkPartyID(endorsedID); err != nil {
+		return nil, err
+	}
+	if endorsedID == bill.HoldBillID {
+		return nil, &InvalidArgumentError{Name: "endorsee", Value: endorsedID}
+	}
+
+	bill.EndorsedID = endorsedID
+	bill.EndorsedName = endorsedName
+	bill.EndorsedRestrictive = restrictive
+	bill.Message = ""
+	bill.State = BillInfo_State_EnWaitSign
+	return putBill(ctx, bill)
+}
+
+// 在背书记录末尾追加一次背书，背书人为当前持票人
+// Append an endorsement from the current holder to the chain
+func appendEndorsement(ctx contractapi.TransactionContextInterface, bill *Bill, endorsementType string, endorseeID string, endorseeName string, restrictive bool) error {
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	bill.Endorsements = append(bill.Endorsements, Endorsement{
+		Type:         endorsementType,
+		EndorserID:   bill.HoldBillID,
+		EndorserName: bill.HoldBillName,
+		EndorseeID:   endorseeID,
+		EndorseeName: endorseeName,
+		Restrictive:  restrictive,
+		Timestamp:    formatTimestamp(now),
+		TxID:         ctx.GetStub().GetTxID(),
+	})
+	return nil
+}
+
+// 同意背书
+// Agree to endorse, the endorsee becomes the accept user and the holder
+func (s *SmartContract) AgreeEndorseBill(ctx contractapi.TransactionContextInterface, billInfoID string) (*Bill, error) {
+	// 读取票据当前状态，校验状态转换
+	// Load the current bill and validate the transition
+	bill, err := getBill(ctx, billInfoID)
+	if err != nil {
+		return nil, err
+	}
+	// 只有被背书人可以签收背书
+	// Only the endorsee can sign the endorsement
+	if err := authorizeParty(ctx, bill.EndorsedID, "sign endorsement of bill "+billInfoID); err != nil {
+		return nil, err
+	}
+	if err := checkTransition(bill, BillInfo_State_EnWaitSign, BillInfo_State_Public); err != nil {
+		return nil, err
+	}
+	// 记录背书，再由被背书人接替持票人
+	// Record the endorsement before the endorsee takes over as holder
+	if err := appendEndorsement(ctx, bill, Endorsement_Endorse, bill.EndorsedID, bill.EndorsedName, bill.EndorsedRestrictive); err != nil {
+		return nil, err
+	}
+
+	bill.AcceptBillID = bill.EndorsedID
+	bill.AcceptBillName = bill.EndorsedName
+	bill.HoldBillID = bill.EndorsedID
+	bill.HoldBillName = bill.EndorsedName
+	// 背书记载"不得转让"的票据不能再转让
+	// A restrictive endorsement stops any further transfer
+	if bill.EndorsedRestrictive {
+		bill.NonTransferable = true
+	}
+	bill.EndorsedID = ""
+	bill.EndorsedName = ""
+	bill.EndorsedRestrictive = false
+	bill.Message = Message_EnSuccess
+	bill.State = BillInfo_State_Public
+	return putBill(ctx, bill)
+}
+
+// 拒绝背书
+// Disagree to endorse
+func (s *SmartContract) DisagreeEndorseBill(ctx contractapi.TransactionContextInterface, billInfoID string) (*Bill, error) {
+	// 读取票据当前状态，校验状态转换
+	// Load the current bill and validate the transition
+	bill, err := getBill(ctx, billInfoID)
+	if err != nil {
+		return nil, err
+	}
+	// 只有被背书人可以拒绝背书
+	// Only the endorsee can refuse the endorsement
+	if err := authorizeParty(ctx, bill.EndorsedID, "refuse endorsement of bill "+billInfoID); err != nil {
+		return nil, err
+	}
+	if err := checkTransition(bill, BillInfo_State_EnWaitSign, BillInfo_State_Public); err != nil {
+		return nil, err
+	}
+
+	bill.EndorsedID = ""
+	bill.EndorsedName = ""
+	bill.EndorsedRestrictive = false
+	bill.Message = Message_EnFail
+	bill.State = BillInfo_State_Public
+	return putBill(ctx, bill)
+}
+
+// 按交易时间更新票据的到期状态：交付状态的票据到期后变为 matured，超过提示付款期变为 overdue
+// 票据不需要更新时不写入账本
+// Move the bill to its maturity state by the transaction time: a public bill becomes matured on its
+// due date and overdue after the presentment period. Nothing is written when the state is current
+func refreshMaturity(ctx contractapi.TransactionContextInterface, bill *Bill, now time.Time) (*Bill, error) {
+	if bill.State != BillInfo_State_Public && bill.State != BillInfo_State_Matured {
+		return bill, nil
+	}
+	state, err := maturityState(bill, now)
+	if err != nil {
+		return nil, err
+	}
+	if state == "" || state == bill.State {
+		return bill, nil
+	}
+	if err := checkTransition(bill, bill.State, state); err != nil {
+		return nil, err
+	}
+	bill.State = state
+	return putBill(ctx, bill)
+}
+
+// 更新一张票据的到期状态，票据当事人、银行或管理员可以调用，与 RefreshAllMaturity 一致
+// Update the maturity state of one bill. Any party of the bill, a bank or an admin may call it,
+// as for RefreshAllMaturity
+func (s *SmartContract) RefreshMaturity(ctx contractapi.TransactionContextInterface, billInfoID string) (*Bill, error) {
+	bill, err := getBill(ctx, billInfoID)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorizeBillParty(ctx, bill, "refresh maturity of bill "+billInfoID); err != nil {
+		return nil, err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return refreshMaturity(ctx, bill, now)
+}
+
+// 更新所有交付及到期状态票据的到期状态，由银行定期调用，返回状态发生变化的票据
+// Update the maturity state of every public and matured bill. Banks call it periodically,
+// the bills whose state changed are returned
+func (s *SmartContract) RefreshAllMaturity(ctx contractapi.TransactionContextInterface) ([]Bill, error) {
+	if err := authorizeRole(ctx, "refresh maturity of all bills", Identity_Role_Bank, Identity_Role_Admin); err != nil {
+		return nil, err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	results := []Bill{}
+	for _, state := range []string{BillInfo_State_Public, BillInfo_State_Matured} {
+		bills, err := getBillsByIndex(ctx, IndexKey_State, []string{state})
+		if err != nil {
+			return nil, err
+		}
+		for i := range bills {
+			bill, err := refreshMaturity(ctx, &bills[i], now)
+			if err != nil {
+				return nil, err
+			}
+			if bill.State != state {
+				results = append(results, *bill)
+			}
+		}
+	}
+	return results, nil
+}
+
+// 已到期票据的状态，按查询顺序排列
+// The states of due bills, in the order they are queried
+var dueBillStates = []string{BillInfo_State_Matured, BillInfo_State_Overdue}
+
+// 查询持票人已到期及逾期的票据
+// Query the holder's matured and overdue bills
+func (s *SmartContract) QueryDueBills(ctx contractapi.TransactionContextInterface, holdBillID string) ([]Bill, error) {
+	if err := authorizePartyQuery(ctx, holdBillID); err != nil {
+		return nil, err
+	}
+	results := []Bill{}
+	for _, state := range dueBillStates {
+		bills, err := getBillsByIndex(ctx, IndexKey_Party, []string{Party_Hold, holdBillID, state})
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, bills...)
+	}
+	return results, nil
+}
+
+// 提示付款 -- 持票人向承兑人提示付款，票据须已到期（交付状态的票据按交易时间判断是否到期）
+// Present the bill for payment: the holder asks the pay user to pay a due bill. A public bill
+// is checked against the transaction time, so it doesn't need refreshing first
+func (s *SmartContract) PresentBill(ctx contractapi.TransactionContextInterface, billInfoID string) (*Bill, error) {
+	// 读取票据当前状态，校验状态转换
+	// Load the current bill and validate the transition
+	bill, err := getBill(ctx, billInfoID)
+	if err != nil {
+		return nil, err
+	}
+	// 只有持票人可以提示付款
+	// Only the holder can present the bill
+	if err := authorizeParty(ctx, bill.HoldBillID, "present bill "+billInfoID); err != nil {
+		return nil, err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if bill.State == BillInfo_State_Public {
+		state, err := maturityState(bill, now)
+		if err != nil {
+			return nil, err
+		}
+		if state != "" {
+			bill.State = state
+		}
+	}
+	if bill.State != BillInfo_State_Matured && bill.State != BillInfo_State_Overdue {
+		return nil, &IllegalStateError{BillInfoID: billInfoID, From: bill.State, To: BillInfo_State_Presented}
+	}
+	if err := checkTransition(bill, bill.State, BillInfo_State_Presented); err != nil {
+		return nil, err
+	}
+
+	bill.Message = ""
+	bill.PresentedAt = formatTimestamp(now)
+	bill.State = BillInfo_State_Presented
+	return putBill(ctx, bill)
+}
+
+// 同意付款 -- 承兑人付款，票据结清
+// Agree to the presentment: the pay user pays and the bill is settled
+func (s *SmartContract) AgreePresentBill(ctx contractapi.TransactionContextInterface, billInfoID string) (*Bill, error) {
+	// 读取票据当前状态，校验状态转换
+	// Load the current bill and validate the transition
+	bill, err := getBill(ctx, billInfoID)
+	if err != nil {
+		return nil, err
+	}
+	// 只有承兑人可以付款
+	// Only the pay user can pay
+	if err := authorizeParty(ctx, bill.PayBillID, "pay presented bill "+billInfoID); err != nil {
+		return nil, err
+	}
+	if err := checkTransition(bill, BillInfo_State_Presented, BillInfo_State_Settled); err != nil {
+		return nil, err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	bill.Message = Message_SettleSuccess
+	bill.SettledAt = formatTimestamp(now)
+	bill.State = BillInfo_State_Settled
+	return putBill(ctx, bill)
+}
+
+// 拒绝付款 -- 承兑人拒绝付款并给出理由
+// Refuse the presentment: the pay user refuses to pay and gives the reason
+func (s *SmartContract) DisagreePresentBill(ctx contractapi.TransactionContextInterface, billInfoID string, reason string) (*Bill, error) {
+	// 读取票据当前状态，校验状态转换
+	// Load the current bill and validate the transition
+	bill, err := getBill(ctx, billInfoID)
+	if err != nil {
+		return nil, err
+	}
+	// 只有承兑人可以拒绝付款
+	// Only the pay user can refuse to pay
+	if err := authorizeParty(ctx, bill.PayBillID, "refuse presented bill "+billInfoID); err != nil {
+		return nil, err
+	}
+	if err := checkTransition(bill, BillInfo_State_Presented, BillInfo_State_Dishonored); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(reason) == "" {
+		return nil, &InvalidArgumentError{Name: "dishonor reason", Value: reason}
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
 	}
-	//结构体转json
-	billAsBytes, _ := json.Marshal(bill)
 
-	return ctx.GetStub().PutState(bill.BillInfoID, billAsBytes)
+	bill.Message = Message_SettleFail
+	bill.SettledAt = formatTimestamp(now)
+	bill.DishonorReason = reason
+	bill.State = BillInfo_State_Dishonored
+	return putBill(ctx, bill)
 }
 
-// 拒绝背书
-// Disagree to endorse
-func (s *SmartContract) DisagreeEndorseBill(ctx contractapi.TransactionContextInterface, billInfoID string, billInfoMoney string, billInfoType string, billInfoIssueDate string, billInfoDueDate string, pubBillID string, pubBillName string, payBillID string, payBillName string, acceptBillID string, acceptBillName string, holdBillID string, holdBillName string) error {
+// 查询已向承兑人提示付款、等待付款的票据
+// Query the bills presented to the pay user and waiting for payment
+func (s *SmartContract) QueryWaitSettleBills(ctx contractapi.TransactionContextInterface, payBillID string) ([]Bill, error) {
+	if err := authorizePartyQuery(ctx, payBillID); err != nil {
+		return nil, err
+	}
+	return getBillsByIndex(ctx, IndexKey_Party, []string{Party_Pay, payBillID, BillInfo_State_Presented})
+}
 
-	bill := Bill{
-		BillInfoID:        billInfoID,
-		BillInfoMoney:     billInfoMoney,
-		BillInfoType:      billInfoType,
-		BillInfoIssueDate: billInfoIssueDate,
-		BillInfoDueDate:   billInfoDueDate,
-		PubBillID:         pubBillID,
-		PubBillName:       pubBillName,
-		PayBillID:         payBillID,
-		PayBillName:       payBillName,
-		AcceptBillID:      acceptBillID,
-		AcceptBillName:    acceptBillName,
-		HoldBillID:        holdBillID,
-		HoldBillName:      holdBillName,
-		EndorsedID:        "",
-		EndorsedName:      "",
-		Message:           "endorsefail",
-		State:             "public",
+// 票据的可追索当事人：出票人、承兑人及持票人的各前手背书人，不包括持票人自己
+// 前手背书人取自背书记录；记录背书之前背书的票据，由票据历史中曾经的持票人得出
+// The parties liable to the holder: the drawer, the acceptor and every prior endorser, never the
+// holder itself. The prior endorsers come from the endorsement chain; for bills endorsed before the
+// chain was recorded they are the earlier holders found in the bill's history
+func liableParties(ctx contractapi.TransactionContextInterface, bill *Bill) ([]LiableParty, error) {
+	parties := []LiableParty{
+		{ID: bill.PubBillID, Name: bill.PubBillName, Role: "drawer"},
+		{ID: bill.PayBillID, Name: bill.PayBillName, Role: "acceptor"},
+	}
+	if len(bill.Endorsements) > 0 {
+		for _, endorsement := range bill.Endorsements {
+			parties = append(parties, LiableParty{ID: endorsement.EndorserID, Name: endorsement.EndorserName, Role: "endorser"})
+		}
+	} else {
+		history, err := getBillHistory(ctx, bill.BillInfoID)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range history {
+			if entry.Value == nil || entry.Value.HoldBillID == "" {
+				continue
+			}
+			parties = append(parties, LiableParty{ID: entry.Value.HoldBillID, Name: entry.Value.HoldBillName, Role: "endorser"})
+		}
+	}
+	// 去重，并排除持票人自己
+	// Drop duplicates and the holder itself
+	seen := map[string]bool{bill.HoldBillID: true}
+	results := []LiableParty{}
+	for _, party := range parties {
+		if party.ID == "" || seen[party.ID] {
+			continue
+		}
+		seen[party.ID] = true
+		results = append(results, party)
+	}
+	return results, nil
+}
+
+// 查询票据的可追索当事人
+// Query the parties the holder can claim against
+func (s *SmartContract) QueryLiableParties(ctx contractapi.TransactionContextInterface, billInfoID string) ([]LiableParty, error) {
+	bill, err := getBill(ctx, billInfoID)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorizeParty(ctx, bill.HoldBillID, "query the liable parties of bill "+billInfoID); err != nil {
+		return nil, err
+	}
+	return liableParties(ctx, bill)
+}
+
+// 当前进行中的追索记录
+// The recourse claim in progress
+func currentRecourse(bill *Bill) (*RecourseRecord, error) {
+	if len(bill.Recourses) == 0 {
+		return nil, &IllegalStateError{BillInfoID: bill.BillInfoID, From: bill.State, To: BillInfo_State_Recourse}
+	}
+	return &bill.Recourses[len(bill.Recourses)-1], nil
+}
+
+// 发起追索 -- 被拒绝付款或逾期后，持票人向可追索当事人追索
+// Claim recourse: after dishonor or when overdue, the holder claims against a liable party
+func (s *SmartContract) ClaimRecourse(ctx contractapi.TransactionContextInterface, billInfoID string, liableID string) (*Bill, error) {
+	// 读取票据当前状态，校验状态转换
+	// Load the current bill and validate the transition
+	bill, err := getBill(ctx, billInfoID)
+	if err != nil {
+		return nil, err
+	}
+	// 只有持票人可以追索
+	// Only the holder can claim recourse
+	if err := authorizeParty(ctx, bill.HoldBillID, "claim recourse on bill "+billInfoID); err != nil {
+		return nil, err
+	}
+	if err := checkTransition(bill, bill.State, BillInfo_State_Recourse); err != nil {
+		return nil, err
+	}
+	if err := checkPartyID(liableID); err != nil {
+		return nil, err
+	}
+	// 被追索人须为可追索当事人
+	// The party claimed against has to be liable
+	parties, err := liableParties(ctx, bill)
+	if err != nil {
+		return nil, err
+	}
+	var liable *LiableParty
+	for i := range parties {
+		if parties[i].ID == liableID {
+			liable = &parties[i]
+		}
+	}
+	if liable == nil {
+		return nil, &InvalidArgumentError{Name: "liable party", Value: liableID}
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	bill.Recourses = append(bill.Recourses, RecourseRecord{
+		LiableID:   liable.ID,
+		LiableName: liable.Name,
+		FromState:  bill.State,
+		Status:     Recourse_Claimed,
+		ClaimedAt:  formatTimestamp(now),
+	})
+	bill.RecourseID = liable.ID
+	bill.RecourseName = liable.Name
+	bill.Message = ""
+	bill.State = BillInfo_State_Recourse
+	return putBill(ctx, bill)
+}
+
+// 同意清偿 -- 被追索人同意向持票人付款
+// Agree to the recourse: the liable party agrees to pay the holder
+func (s *SmartContract) AgreeRecourse(ctx contractapi.TransactionContextInterface, billInfoID string) (*Bill, error) {
+	// 读取票据当前状态，校验状态转换
+	// Load the current bill and validate the transition
+	bill, err := getBill(ctx, billInfoID)
+	if err != nil {
+		return nil, err
+	}
+	// 只有被追索人可以同意清偿
+	// Only the party claimed against can agree
+	if err := authorizeParty(ctx, bill.RecourseID, "agree to recourse on bill "+billInfoID); err != nil {
+		return nil, err
+	}
+	if err := checkTransition(bill, BillInfo_State_Recourse, BillInfo_State_RecourseAgreed); err != nil {
+		return nil, err
+	}
+	record, err := currentRecourse(bill)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	record.Status = Recourse_Agreed
+	record.AnsweredAt = formatTimestamp(now)
+	bill.Message = Message_RecourseSuccess
+	bill.State = BillInfo_State_RecourseAgreed
+	return putBill(ctx, bill)
+}
+
+// 拒绝清偿 -- 被追索人拒绝付款，票据回到追索前的状态，持票人可以向其他当事人追索
+// Refuse the recourse: the liable party refuses to pay. The bill goes back to the state the claim
+// was made from, so the holder can claim against another party
+func (s *SmartContract) DisagreeRecourse(ctx contractapi.TransactionContextInterface, billInfoID string, reason string) (*Bill, error) {
+	// 读取票据当前状态，校验状态转换
+	// Load the current bill and validate the transition
+	bill, err := getBill(ctx, billInfoID)
+	if err != nil {
+		return nil, err
+	}
+	// 只有被追索人可以拒绝清偿
+	// Only the party claimed against can refuse
+	if err := authorizeParty(ctx, bill.RecourseID, "refuse recourse on bill "+billInfoID); err != nil {
+		return nil, err
+	}
+	record, err := currentRecourse(bill)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTransition(bill, BillInfo_State_Recourse, record.FromState); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(reason) == "" {
+		return nil, &InvalidArgumentError{Name: "refusal reason", Value: reason}
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	record.Status = Recourse_Refused
+	record.Reason = reason
+	record.AnsweredAt = formatTimestamp(now)
+	bill.RecourseID = ""
+	bill.RecourseName = ""
+	bill.Message = Message_RecourseFail
+	bill.State = record.FromState
+	return putBill(ctx, bill)
+}
+
+// 结清追索 -- 持票人确认已收到被追索人的付款，票据结清
+// Settle the recourse: the holder confirms the liable party paid, the bill is settled
+func (s *SmartContract) SettleRecourse(ctx contractapi.TransactionContextInterface, billInfoID string) (*Bill, error) {
+	// 读取票据当前状态，校验状态转换
+	// Load the current bill and validate the transition
+	bill, err := getBill(ctx, billInfoID)
+	if err != nil {
+		return nil, err
+	}
+	// 只有持票人可以确认收款
+	// Only the holder can confirm the payment
+	if err := authorizeParty(ctx, bill.HoldBillID, "settle recourse on bill "+billInfoID); err != nil {
+		return nil, err
+	}
+	if err := checkTransition(bill, BillInfo_State_RecourseAgreed, BillInfo_State_Settled); err != nil {
+		return nil, err
+	}
+	record, err := currentRecourse(bill)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	record.Status = Recourse_Settled
+	record.SettledAt = formatTimestamp(now)
+	bill.SettledAt = record.SettledAt
+	bill.State = BillInfo_State_Settled
+	return putBill(ctx, bill)
+}
+
+// 查询向自己发起、等待答复的追索
+// Query the recourse claims made against the company and waiting for its answer
+func (s *SmartContract) QueryWaitRecourseBills(ctx contractapi.TransactionContextInterface, recourseID string) ([]Bill, error) {
+	if err := authorizePartyQuery(ctx, recourseID); err != nil {
+		return nil, err
+	}
+	return getBillsByIndex(ctx, IndexKey_Party, []string{Party_Recourse, recourseID, BillInfo_State_Recourse})
+}
+
+// 读取票据的历史记录，包含每笔交易的ID、时间、删除标志及调用者
+// Read the bill's history: the ID, time, deletion flag and invoker of every transaction
+func getBillHistory(ctx contractapi.TransactionContextInterface, billInfoID string) ([]HistoryEntry, error) {
+	key, err := billKey(ctx, billInfoID)
+	if err != nil {
+		return nil, err
+	}
+	resultsIterator, err := ctx.GetStub().GetHistoryForKey(key)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	results := []HistoryEntry{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		entry := HistoryEntry{TxID: queryResponse.TxId, IsDelete: queryResponse.IsDelete}
+		if ts := queryResponse.Timestamp; ts != nil {
+			entry.Timestamp = formatTimestamp(time.Unix(ts.Seconds, int64(ts.Nanos)))
+		}
+		// 删除操作没有写入值
+		// A delete writes no value
+		if !queryResponse.IsDelete && len(queryResponse.Value) > 0 {
+			bill := new(Bill)
+			err = json.Unmarshal(queryResponse.Value, bill)
+			if err != nil {
+				return nil, err
+			}
+			entry.Value = bill
+			entry.MSPID = bill.UpdatedByMSP
+			entry.Invoker = bill.UpdatedBy
+		}
+		results = append(results, entry)
+	}
+
+	return results, nil
+}
+
+// 根据id查询bill的历史记录
+// Query the bill's operation history
+func (s *SmartContract) QueryHistoryById(ctx contractapi.TransactionContextInterface, billInfoID string) ([]HistoryEntry, error) {
+	bill, err := getBill(ctx, billInfoID)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorizeBillRead(ctx, bill); err != nil {
+		return nil, err
+	}
+	return getBillHistory(ctx, billInfoID)
+}
+
+// 根据id查询bill
+// Query bill by ID
+func (s *SmartContract) QueryBillById(ctx contractapi.TransactionContextInterface, id string) (*Bill, error) {
+	bill, err := getBill(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorizeBillRead(ctx, bill); err != nil {
+		return nil, err
+	}
+	return bill, nil
+}
+
+//条件查询\ 查询state为DcWaitSigned 等待被贴现签收的所有票据
+// Query the bills waiting for discounting, accordin to state 'DcWaitSigned'
+func (s *SmartContract) QueryWaitDiscountBills(ctx contractapi.TransactionContextInterface) ([]Bill, error) {
+	if err := authorizeAllBillsQuery(ctx, "query the bills waiting for discounting"); err != nil {
+		return nil, err
+	}
+	// 根据状态索引查询
+	// Look up the bills through the state index
+	return getBillsByIndex(ctx, IndexKey_State, []string{BillInfo_State_DcWaitSigned})
+}
+
+//条件查询\ 需要查询 PayBillID 为个人 和 State 为 Made 的等待被承兑签收的所有票据
+//  Query all the bill's which are waiting for the company to pay, according to company's ID and bill state 'Made'
+func (s *SmartContract) QueryWaitPayBills(ctx contractapi.TransactionContextInterface, payBillID string) ([]Bill, error) {
+	if err := authorizePartyQuery(ctx, payBillID); err != nil {
+		return nil, err
+	}
+	// 根据当事人索引查询
+	// Look up the bills through the party index
+	return getBillsByIndex(ctx, IndexKey_Party, []string{Party_Pay, payBillID, BillInfo_State_Made})
+}
+
+//条件查询\-查看pay身份的bill且state为Public的票据
+// Query bills which need to pay and state is 'Public'
+func (s *SmartContract) QueryAllPayBills(ctx contractapi.TransactionContextInterface, payBillID string) ([]Bill, error) {
+	if err := authorizePartyQuery(ctx, payBillID); err != nil {
+		return nil, err
+	}
+	// 根据当事人索引查询
+	// Look up the bills through the party index
+	return getBillsByIndex(ctx, IndexKey_Party, []string{Party_Pay, payBillID, BillInfo_State_Public})
+}
+
+//条件查询\-查看accept身份的bill且state为Public的票据
+// Query bills which need to accept and state is 'Public'
+func (s *SmartContract) QueryAllAcceptBills(ctx contractapi.TransactionContextInterface, acceptBillID string) ([]Bill, error) {
+	if err := authorizePartyQuery(ctx, acceptBillID); err != nil {
+		return nil, err
+	}
+	// 根据当事人索引查询
+	// Look up the bills through the party index
+	return getBillsByIndex(ctx, IndexKey_Party, []string{Party_Accept, acceptBillID, BillInfo_State_Public})
+}
+
+//条件查询\-查看hold身份的bill且state为Public的票据
+// Query bills which need to hold and state is 'Public'
+func (s *SmartContract) QueryAllHoldBills(ctx contractapi.TransactionContextInterface, holdBillID string) ([]Bill, error) {
+	if err := authorizePartyQuery(ctx, holdBillID); err != nil {
+		return nil, err
+	}
+	// 根据当事人索引查询
+	// Look up the bills through the party index
+	return getBillsByIndex(ctx, IndexKey_Party, []string{Party_Hold, holdBillID, BillInfo_State_Public})
+}
+
+//条件查询\-依据 EndorsedID 和 State 为 EnWaitSign 查询
+// Query the bill waiting for signed to endorse
+func (s *SmartContract) QueryWaitEndorseBills(ctx contractapi.TransactionContextInterface, endorsedID string) ([]Bill, error) {
+	if err := authorizePartyQuery(ctx, endorsedID); err != nil {
+		return nil, err
+	}
+	// 根据当事人索引查询
+	// Look up the bills through the party index
+	return getBillsByIndex(ctx, IndexKey_Party, []string{Party_Endorsed, endorsedID, BillInfo_State_EnWaitSign})
+}
+
+// CouchDB 查询，由结构体序列化生成，参数不会被解释为查询语法
+// A CouchDB query. It is marshalled from typed values, so no argument can inject selector syntax
+type couchQuery struct {
+	Selector map[string]interface{} `json:"selector"`
+	Sort     []map[string]string    `json:"sort,omitempty"`
+	UseIndex []string               `json:"use_index,omitempty"`
+}
+
+// 搜索条件错误
+// InvalidSearchError is returned when a search filter is malformed
+type InvalidSearchError struct {
+	Reason string
+}
+
+func (e *InvalidSearchError) Error() string {
+	return fmt.Sprintf("invalid search: %s", e.Reason)
+}
+
+// 校验搜索条件
+// Check the search filters are well formed
+func checkSearch(search *BillSearch) error {
+	if search.PartyID != "" {
+		if err := checkPartyID(search.PartyID); err != nil {
+			return err
+		}
+	}
+	if search.IDPrefix != "" {
+		if err := checkBillID(search.IDPrefix); err != nil {
+			return err
+		}
+	}
+	for _, state := range search.States {
+		if !containsString(billStates, state) {
+			return &InvalidSearchError{Reason: fmt.Sprintf("unknown state %q", state)}
+		}
+	}
+	for _, amount := range []string{search.MinAmount, search.MaxAmount} {
+		if _, err := parseFen(amount); amount != "" && err != nil {
+			return &InvalidSearchError{Reason: fmt.Sprintf("malformed amount %q", amount)}
+		}
+	}
+	if search.Currency != "" && !currencyPattern.MatchString(search.Currency) {
+		return &InvalidSearchError{Reason: fmt.Sprintf("malformed currency %q", search.Currency)}
+	}
+	for _, date := range []string{search.IssueDateFrom, search.IssueDateTo, search.DueDateFrom, search.DueDateTo} {
+		if _, err := time.Parse(DateLayout, date); date != "" && err != nil {
+			return &InvalidSearchError{Reason: fmt.Sprintf("malformed date %q", date)}
+		}
+	}
+	if _, ok := searchSortIndexes[search.SortBy]; search.SortBy != "" && !ok {
+		return &InvalidSearchError{Reason: fmt.Sprintf("cannot sort on %q", search.SortBy)}
+	}
+	if search.SortOrder != "" && search.SortOrder != "asc" && search.SortOrder != "desc" {
+		return &InvalidSearchError{Reason: fmt.Sprintf("unknown sort order %q", search.SortOrder)}
+	}
+	return nil
+}
+
+// 由搜索条件生成 CouchDB 查询
+// Build the CouchDB query of the search filters
+func searchQuery(search *BillSearch) (string, error) {
+	selector := map[string]interface{}{"docType": DocType_Bill}
+	if len(search.States) > 0 {
+		selector["State"] = map[string]interface{}{"$in": search.States}
+	}
+	if search.PartyID != "" {
+		// 与 billParties 相同的当事人，包括背书记录中的背书人和被背书人
+		// The same parties as billParties, the endorsers and endorsees of the chain included
+		var parties []interface{}
+		for _, party := range billPartyFields {
+			parties = append(parties, map[string]string{party.field: search.PartyID})
+		}
+		for _, party := range endorsementPartyFields {
+			parties = append(parties, map[string]interface{}{"Endorsements": map[string]interface{}{"$elemMatch": map[string]string{party.field: search.PartyID}}})
+		}
+		selector["$or"] = parties
+	}
+	if search.BillType != "" {
+		selector["BillInfoType"] = search.BillType
+	}
+	if search.IDPrefix != "" {
+		// 前缀匹配转换为范围查询，可以使用索引
+		// A prefix is matched as a range, so the index can be used
+		selector["BillInfoID"] = map[string]string{"$gte": search.IDPrefix, "$lt": search.IDPrefix + string(utf8.MaxRune)}
+	}
+	if search.Currency != "" {
+		selector["BillInfoMoney.currency"] = search.Currency
+	}
+	// 早于此标记发布的票据没有 NonTransferable 字段，视为可以转让
+	// Bills issued before the flag existed have no NonTransferable field and are transferable
+	if search.Transferable != nil {
+		if *search.Transferable {
+			selector["NonTransferable"] = map[string]interface{}{"$ne": true}
+		} else {
+			selector["NonTransferable"] = true
+		}
+	}
+	// 金额以分为单位比较
+	// Amounts are compared in fen
+	amountRange := map[string]int64{}
+	if fen, err := parseFen(search.MinAmount); err == nil {
+		amountRange["$gte"] = fen
+	}
+	if fen, err := parseFen(search.MaxAmount); err == nil {
+		amountRange["$lte"] = fen
+	}
+	if len(amountRange) > 0 {
+		selector["BillInfoMoney.fen"] = amountRange
+	}
+	dateRange := func(field string, from string, to string) {
+		condition := map[string]string{}
+		if from != "" {
+			condition["$gte"] = from
+		}
+		if to != "" {
+			condition["$lte"] = to
+		}
+		if len(condition) > 0 {
+			selector[field] = condition
+		}
+	}
+	dateRange("BillInfoIssueDate", search.IssueDateFrom, search.IssueDateTo)
+	dateRange("BillInfoDueDate", search.DueDateFrom, search.DueDateTo)
+
+	query := couchQuery{Selector: selector}
+	if search.SortBy != "" {
+		order := search.SortOrder
+		if order == "" {
+			order = "asc"
+		}
+		// 排序字段需要出现在查询条件中，才能使用对应的索引
+		// The sort field has to be in the selector for its index to be used
+		if _, ok := selector[search.SortBy]; !ok {
+			selector[search.SortBy] = map[string]interface{}{"$gt": nil}
+		}
+		index := searchSortIndexes[search.SortBy]
+		query.Sort = []map[string]string{{"docType": order}, {search.SortBy: order}}
+		query.UseIndex = []string{"_design/" + index + "Doc", index}
+	} else {
+		for _, candidate := range searchFilterIndexes {
+			if _, ok := selector[candidate.field]; ok {
+				query.UseIndex = []string{"_design/" + candidate.index + "Doc", candidate.index}
+				break
+			}
+		}
+	}
+	queryAsBytes, err := json.Marshal(query)
+	if err != nil {
+		return "", err
+	}
+	return string(queryAsBytes), nil
+}
+
+// 判断字符串是否在列表中
+// Check whether the list contains the string
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
 	}
-	//结构体转json
-	billAsBytes, _ := json.Marshal(bill)
-
-	return ctx.GetStub().PutState(bill.BillInfoID, billAsBytes)
+	return false
 }
 
-// 根据id查询bill的历史记录
-// Query the bill's operation history
-func (s *SmartContract) QueryHistoryById(ctx contractapi.TransactionContextInterface, billInfoID string) ([]Bill, error) {
-
-	resultsIterator, err := ctx.GetStub().GetHistoryForKey(billInfoID)
+// 从迭代器中读取一页票据，跳过金额无法识别的旧票据，这些票据只能按编号查看
+// Read the bills of one page from the iterator. Legacy bills whose amount can't be parsed are
+// skipped, they are only read by ID
+func readBills(resultsIterator shim.StateQueryIteratorInterface) ([]Bill, error) {
+	bills, err := decodeBills(resultsIterator)
 	if err != nil {
 		return nil, err
 	}
-	defer resultsIterator.Close()
-
-	var results []Bill
+	results := []Bill{}
+	for _, bill := range bills {
+		if bill.BillInfoMoney.Invalid != "" {
+			continue
+		}
+		results = append(results, bill)
+	}
+	return results, nil
+}
 
+// 从迭代器中读取全部票据
+// Decode every bill of the iterator
+func decodeBills(resultsIterator shim.StateQueryIteratorInterface) ([]Bill, error) {
+	results := []Bill{}
 	for resultsIterator.HasNext() {
 		queryResponse, err := resultsIterator.Next()
 		if err != nil {
@@ -515,203 +2695,266 @@ func (s *SmartContract) QueryHistoryById(ctx contractapi.TransactionContextInter
 		}
 		results = append(results, bill)
 	}
-
 	return results, nil
 }
 
-// 根据id查询bill
-// Query bill by ID
-func (s *SmartContract) QueryBillById(ctx contractapi.TransactionContextInterface, id string) (*Bill, error) {
+// 校验每页数量
+// Check the page size
+func checkPageSize(pageSize int32) error {
+	if pageSize <= 0 || pageSize > MaxPageSize {
+		return fmt.Errorf("page size must be between 1 and %d", MaxPageSize)
+	}
+	return nil
+}
 
-	billAsBytes, err := ctx.GetStub().GetState(id)
+// 按索引组合键查询票据，组合键的最后一个属性为票据编号
+// Query the bills through an index composite key, whose last attribute is the bill ID
+func getBillsByIndex(ctx contractapi.TransactionContextInterface, objectType string, attributes []string) ([]Bill, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(objectType, attributes)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
-	}
-	if billAsBytes == nil {
-		return nil, fmt.Errorf("%s does not exist", id)
+		return nil, err
 	}
+	defer resultsIterator.Close()
 
-	bill := new(Bill)
-	_ = json.Unmarshal(billAsBytes, bill)
-
-	return bill, nil
+	return readIndexedBills(ctx, resultsIterator)
 }
 
-//条件查询\ 查询state为DcWaitSigned 等待被贴现签收的所有票据
-// Query the bills waiting for discounting, accordin to state 'DcWaitSigned'
-func (s *SmartContract) QueryWaitDiscountBills(ctx contractapi.TransactionContextInterface) ([]Bill, error) {
-
-	//拼接查询字符串
-	// Build a string for search condition
-	queryString := fmt.Sprintf("{\"selector\":{\"State\":\"dcwaitsigned\"}}")
-	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+// 按索引组合键分页查询票据
+// Query one page of bills through an index composite key
+func getBillsByIndexWithPagination(ctx contractapi.TransactionContextInterface, objectType string, attributes []string, pageSize int32, bookmark string) (*PaginatedBills, error) {
+	err := checkPageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(objectType, attributes, pageSize, bookmark)
 	if err != nil {
 		return nil, err
 	}
 	defer resultsIterator.Close()
 
-	var results []Bill
+	bills, err := readIndexedBills(ctx, resultsIterator)
+	if err != nil {
+		return nil, err
+	}
+	return &PaginatedBills{Bills: bills, FetchedRecordsCount: metadata.FetchedRecordsCount, Bookmark: metadata.Bookmark}, nil
+}
 
+// 读取索引组合键指向的票据
+// Load the bills the index composite keys point at
+func readIndexedBills(ctx contractapi.TransactionContextInterface, resultsIterator shim.StateQueryIteratorInterface) ([]Bill, error) {
+	results := []Bill{}
 	for resultsIterator.HasNext() {
 		queryResponse, err := resultsIterator.Next()
 		if err != nil {
 			return nil, err
 		}
-		var bill Bill
-		err = json.Unmarshal(queryResponse.Value, &bill)
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
 		if err != nil {
 			return nil, err
 		}
-		results = append(results, bill)
+		bill, err := getBill(ctx, attributes[len(attributes)-1])
+		if err != nil {
+			return nil, err
+		}
+		// 升级前的索引可能指向金额无法识别的旧票据
+		// An index key from before the upgrade may point at a legacy bill whose amount can't be parsed
+		if bill.BillInfoMoney.Invalid != "" {
+			continue
+		}
+		results = append(results, *bill)
 	}
 	return results, nil
 }
 
-//条件查询\ 需要查询 PayBillID 为个人 和 State 为 Made 的等待被承兑签收的所有票据
-//  Query all the bill's which are waiting for the company to pay, according to company's ID and bill state 'Made'
-func (s *SmartContract) QueryWaitPayBills(ctx contractapi.TransactionContextInterface, payBillID string) ([]Bill, error) {
-	//获取发起方的公司名
-	PayBillID := strings.ToLower(payBillID)
-	//拼接查询字符串
-	queryString := fmt.Sprintf("{\"selector\":{\"PayBillID\":\"%s\",\"State\":\"made\"}}", PayBillID)
-	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+// 分页查询所有票据信息
+// Query one page of all the bill infos
+func (s *SmartContract) QueryAllBillWithPagination(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*PaginatedBills, error) {
+	if err := authorizeAllBillsQuery(ctx, "query all the bills"); err != nil {
+		return nil, err
+	}
+	err := checkPageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+	// 根据组合键前缀分页查询
+	// Iterate over one page of the "bill" composite keys
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(DocType_Bill, []string{}, pageSize, bookmark)
 	if err != nil {
 		return nil, err
 	}
 	defer resultsIterator.Close()
 
-	var results []Bill
-
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
-		var bill Bill
-		err = json.Unmarshal(queryResponse.Value, &bill)
-		if err != nil {
-			return nil, err
-		}
-		results = append(results, bill)
+	bills, err := readBills(resultsIterator)
+	if err != nil {
+		return nil, err
 	}
-	return results, nil
+	return &PaginatedBills{Bills: bills, FetchedRecordsCount: metadata.FetchedRecordsCount, Bookmark: metadata.Bookmark}, nil
 }
 
-//条件查询\-查看pay身份的bill且state为Public的票据
-// Query bills which need to pay and state is 'Public'
-func (s *SmartContract) QueryAllPayBills(ctx contractapi.TransactionContextInterface, payBillID string) ([]Bill, error) {
+// 分页查询待贴现票据
+// Query one page of the bills waiting for discounting
+func (s *SmartContract) QueryWaitDiscountBillsWithPagination(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*PaginatedBills, error) {
+	if err := authorizeAllBillsQuery(ctx, "query the bills waiting for discounting"); err != nil {
+		return nil, err
+	}
+	return getBillsByIndexWithPagination(ctx, IndexKey_State, []string{BillInfo_State_DcWaitSigned}, pageSize, bookmark)
+}
 
-	// 拼接查询字符串，根据传入的PayBillID参数和票据状态查询符合要求的票据
-	queryString := fmt.Sprintf("{\"selector\":{\"PayBillID\":\"%s\", \"State\":\"public\"}}", payBillID)
-	// 将查询字符串传入查询方法
-	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
-	if err != nil {
+// 分页查询待承兑票据
+// Query one page of the bills waiting for the company to pay
+func (s *SmartContract) QueryWaitPayBillsWithPagination(ctx contractapi.TransactionContextInterface, payBillID string, pageSize int32, bookmark string) (*PaginatedBills, error) {
+	if err := authorizePartyQuery(ctx, payBillID); err != nil {
 		return nil, err
 	}
-	defer resultsIterator.Close()
-	// 用以接收查询结果
-	var results []Bill
-	// 遍历返回数据，将其加入Bill数组中。
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
-		var bill Bill
-		err = json.Unmarshal(queryResponse.Value, &bill)
-		if err != nil {
-			return nil, err
-		}
-		results = append(results, bill)
+	return getBillsByIndexWithPagination(ctx, IndexKey_Party, []string{Party_Pay, payBillID, BillInfo_State_Made}, pageSize, bookmark)
+}
+
+// 分页查询pay身份且state为Public的票据
+// Query one page of the bills to pay in state 'Public'
+func (s *SmartContract) QueryAllPayBillsWithPagination(ctx contractapi.TransactionContextInterface, payBillID string, pageSize int32, bookmark string) (*PaginatedBills, error) {
+	if err := authorizePartyQuery(ctx, payBillID); err != nil {
+		return nil, err
 	}
-	return results, nil
+	return getBillsByIndexWithPagination(ctx, IndexKey_Party, []string{Party_Pay, payBillID, BillInfo_State_Public}, pageSize, bookmark)
 }
 
-//条件查询\-查看accept身份的bill且state为Public的票据
-// Query bills which need to accept and state is 'Public'
-func (s *SmartContract) QueryAllAcceptBills(ctx contractapi.TransactionContextInterface, acceptBillID string) ([]Bill, error) {
+// 分页查询accept身份且state为Public的票据
+// Query one page of the bills to accept in state 'Public'
+func (s *SmartContract) QueryAllAcceptBillsWithPagination(ctx contractapi.TransactionContextInterface, acceptBillID string, pageSize int32, bookmark string) (*PaginatedBills, error) {
+	if err := authorizePartyQuery(ctx, acceptBillID); err != nil {
+		return nil, err
+	}
+	return getBillsByIndexWithPagination(ctx, IndexKey_Party, []string{Party_Accept, acceptBillID, BillInfo_State_Public}, pageSize, bookmark)
+}
 
-	// 拼接查询字符串
-	queryString := fmt.Sprintf("{\"selector\":{\"AcceptBillID\":\"%s\", \"State\":\"public\"}}", acceptBillID)
-	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
-	if err != nil {
+// 分页查询hold身份且state为Public的票据
+// Query one page of the bills held in state 'Public'
+func (s *SmartContract) QueryAllHoldBillsWithPagination(ctx contractapi.TransactionContextInterface, holdBillID string, pageSize int32, bookmark string) (*PaginatedBills, error) {
+	if err := authorizePartyQuery(ctx, holdBillID); err != nil {
 		return nil, err
 	}
-	defer resultsIterator.Close()
+	return getBillsByIndexWithPagination(ctx, IndexKey_Party, []string{Party_Hold, holdBillID, BillInfo_State_Public}, pageSize, bookmark)
+}
 
-	var results []Bill
+// 分页查询待背书签收的票据
+// Query one page of the bills waiting for signing the endorsement
+func (s *SmartContract) QueryWaitEndorseBillsWithPagination(ctx contractapi.TransactionContextInterface, endorsedID string, pageSize int32, bookmark string) (*PaginatedBills, error) {
+	if err := authorizePartyQuery(ctx, endorsedID); err != nil {
+		return nil, err
+	}
+	return getBillsByIndexWithPagination(ctx, IndexKey_Party, []string{Party_Endorsed, endorsedID, BillInfo_State_EnWaitSign}, pageSize, bookmark)
+}
 
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
+// 分页查询持票人已到期及逾期的票据。两种状态依次分页，一页不足时由下一种状态补足；
+// 书签为 状态序号:该状态内的书签
+// Query one page of the holder's matured and overdue bills. The states are paged one after the other,
+// the next state fills up a page the previous one leaves short. The bookmark is the state's position,
+// a colon and the bookmark within that state
+func (s *SmartContract) QueryDueBillsWithPagination(ctx contractapi.TransactionContextInterface, holdBillID string, pageSize int32, bookmark string) (*PaginatedBills, error) {
+	if err := authorizePartyQuery(ctx, holdBillID); err != nil {
+		return nil, err
+	}
+	err := checkPageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+	position, stateBookmark := 0, ""
+	if bookmark != "" {
+		parts := strings.SplitN(bookmark, ":", 2)
+		position, err = strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 || position < 0 || position >= len(dueBillStates) {
+			return nil, &InvalidArgumentError{Name: "bookmark", Value: bookmark}
 		}
-		var bill Bill
-		err = json.Unmarshal(queryResponse.Value, &bill)
+		stateBookmark = parts[1]
+	}
+	results := &PaginatedBills{Bills: []Bill{}}
+	for position < len(dueBillStates) && results.FetchedRecordsCount < pageSize {
+		remaining := pageSize - results.FetchedRecordsCount
+		page, err := getBillsByIndexWithPagination(ctx, IndexKey_Party, []string{Party_Hold, holdBillID, dueBillStates[position]}, remaining, stateBookmark)
 		if err != nil {
 			return nil, err
 		}
-		results = append(results, bill)
+		results.Bills = append(results.Bills, page.Bills...)
+		results.FetchedRecordsCount += page.FetchedRecordsCount
+		// 本状态已读完，转到下一种状态
+		// This state is exhausted, go on with the next one
+		if page.FetchedRecordsCount < remaining || page.Bookmark == "" {
+			position, stateBookmark = position+1, ""
+			continue
+		}
+		stateBookmark = page.Bookmark
+	}
+	if position < len(dueBillStates) {
+		results.Bookmark = strconv.Itoa(position) + ":" + stateBookmark
 	}
 	return results, nil
 }
 
-//条件查询\-查看hold身份的bill且state为Public的票据
-// Query bills which need to hold and state is 'Public'
-func (s *SmartContract) QueryAllHoldBills(ctx contractapi.TransactionContextInterface, holdBillID string) ([]Bill, error) {
-
-	// 拼接查询字符串
-	queryString := fmt.Sprintf("{\"selector\":{\"HoldBillID\":\"%s\", \"State\":\"public\"}}", holdBillID)
-	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
-	if err != nil {
+// 分页查询已向承兑人提示付款、等待付款的票据
+// Query one page of the bills presented to the pay user and waiting for payment
+func (s *SmartContract) QueryWaitSettleBillsWithPagination(ctx contractapi.TransactionContextInterface, payBillID string, pageSize int32, bookmark string) (*PaginatedBills, error) {
+	if err := authorizePartyQuery(ctx, payBillID); err != nil {
 		return nil, err
 	}
-	defer resultsIterator.Close()
+	return getBillsByIndexWithPagination(ctx, IndexKey_Party, []string{Party_Pay, payBillID, BillInfo_State_Presented}, pageSize, bookmark)
+}
 
-	var results []Bill
+// 分页查询向自己发起、等待答复的追索
+// Query one page of the recourse claims made against the company and waiting for its answer
+func (s *SmartContract) QueryWaitRecourseBillsWithPagination(ctx contractapi.TransactionContextInterface, recourseID string, pageSize int32, bookmark string) (*PaginatedBills, error) {
+	if err := authorizePartyQuery(ctx, recourseID); err != nil {
+		return nil, err
+	}
+	return getBillsByIndexWithPagination(ctx, IndexKey_Party, []string{Party_Recourse, recourseID, BillInfo_State_Recourse}, pageSize, bookmark)
+}
 
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
+// 按条件搜索票据，使用 CouchDB 富查询并分页返回。银行和管理员以外只能搜索自己作为当事人的票据
+// Search the bills by filters with a CouchDB rich query, one page at a time. Anyone but banks and admins
+// can only search the bills they are a party of
+func (s *SmartContract) SearchBills(ctx contractapi.TransactionContextInterface, filter string, pageSize int32, bookmark string) (*PaginatedBills, error) {
+	search := new(BillSearch)
+	if filter != "" {
+		err := json.Unmarshal([]byte(filter), search)
 		if err != nil {
-			return nil, err
+			return nil, &InvalidSearchError{Reason: err.Error()}
 		}
-		var bill Bill
-		err = json.Unmarshal(queryResponse.Value, &bill)
+	}
+	err := checkSearch(search)
+	if err != nil {
+		return nil, err
+	}
+	err = checkPageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+	caller, err := getCaller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !canReadAllBills(caller) {
+		if search.PartyID == "" {
+			search.PartyID = caller.CompanyID
+		}
+		err = requireParty(caller, search.PartyID, "search the bills of "+search.PartyID)
 		if err != nil {
 			return nil, err
 		}
-		results = append(results, bill)
 	}
-	return results, nil
-}
-
-//条件查询\-依据 EndorsedID 和 State 为 EnWaitSign 查询
-// Query the bill waiting for signed to endorse
-func (s *SmartContract) QueryWaitEndorseBills(ctx contractapi.TransactionContextInterface, endorsedID string) ([]Bill, error) {
-
-	// 拼接查询字符串
-	queryString := fmt.Sprintf("{\"selector\":{\"EndorsedID\":\"%s\", \"State\":\"enwaitsign\"}}", endorsedID)
-	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+	queryString, err := searchQuery(search)
+	if err != nil {
+		return nil, err
+	}
+	resultsIterator, metadata, err := ctx.GetStub().GetQueryResultWithPagination(queryString, pageSize, bookmark)
 	if err != nil {
 		return nil, err
 	}
 	defer resultsIterator.Close()
 
-	var results []Bill
-
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
-		var bill Bill
-		err = json.Unmarshal(queryResponse.Value, &bill)
-		if err != nil {
-			return nil, err
-		}
-		results = append(results, bill)
+	bills, err := readBills(resultsIterator)
+	if err != nil {
+		return nil, err
 	}
-	return results, nil
+	return &PaginatedBills{Bills: bills, FetchedRecordsCount: metadata.FetchedRecordsCount, Bookmark: metadata.Bookmark}, nil
 }
 
 // 贴现/背书请求 -- 更改收款人和持票人
@@ -722,53 +2965,77 @@ func (s *SmartContract) DiscountAndEndorse(ctx contractapi.TransactionContextInt
 	}
 	// 以Bill的id查询票据
 	billid := args[0]
-	billinfo, err := ctx.GetStub().GetState(billid)
-	var bill Bill
-	err = json.Unmarshal([]byte(billinfo), &bill)
+	// 只有管理员可以直接修改收款人和持票人
+	// Only admins can change the accept and hold users directly
+	if err := authorizeRole(ctx, "change holder of bill "+billid, Identity_Role_Admin); err != nil {
+		return err
+	}
+	bill, err := getBill(ctx, billid)
 	// 如果查询错误或为空，则说明账号错误
 	if err != nil {
 		return err
 	}
+	// 只有交付状态的票据才能转让
+	// Only a "public" bill can be transferred
+	if bill.State != BillInfo_State_Public {
+		return &IllegalStateError{BillInfoID: billid, From: bill.State, To: BillInfo_State_Public}
+	}
+	if err := checkPartyID(args[1]); err != nil {
+		return err
+	}
+	if err := checkPartyID(args[3]); err != nil {
+		return err
+	}
+	// 与背书、贴现一样，到期或记载"不得转让"的票据不能转让
+	// As for endorsing and discounting, a due bill or one marked "not transferable" can't be transferred
+	if err := checkNotDue(ctx, bill); err != nil {
+		return err
+	}
+	if err := checkTransferable(bill); err != nil {
+		return err
+	}
+	// 转让记入背书记录，背书人为当前持票人
+	// The transfer goes into the endorsement chain, from the current holder
+	if err := appendEndorsement(ctx, bill, Endorsement_Endorse, args[3], args[4], false); err != nil {
+		return err
+	}
 	// 修改bill的收款人和持票人
 	bill.AcceptBillID = args[1]
 	bill.AcceptBillName = args[2]
 	bill.HoldBillID = args[3]
 	bill.HoldBillName = args[4]
 	// 将修改完的bill存储回区块链
-	jsonString, err := json.Marshal(bill)
-	fmt.Println("json:" + string(jsonString))
-	if err != nil {
-		return errors.New("DiscountAndEndorse-json序列化失败")
-	}
-	return ctx.GetStub().PutState(billid, []byte(jsonString))
+	_, err = putBill(ctx, bill)
+	return err
 }
 
 // 参加承兑 -- 更改承兑人
-// Chaneg the bill's accept infos
-func (s *SmartContract) ChangeAccept(ctx contractapi.TransactionContextInterface, args []string) error {
-	if len(args) != 3 {
-		return errors.New("ChangeAccept-参数出错")
+// Chaneg the bill's pay user infos
+func (s *SmartContract) ChangeAccept(ctx contractapi.TransactionContextInterface, billInfoID string, payBillID string, payBillName string) (*Bill, error) {
+	// 只有银行可以更换承兑人
+	// Only banks can change the pay user
+	if err := authorizeRole(ctx, "change pay user of bill "+billInfoID, Identity_Role_Bank); err != nil {
+		return nil, err
 	}
 	// 以Bill的id查询票据
-	billid := args[0]
-	billinfo, err := ctx.GetStub().GetState(billid)
-	var bill Bill
-	err = json.Unmarshal([]byte(billinfo), &bill)
+	bill, err := getBill(ctx, billInfoID)
 	// 如果查询错误或为空，则说明账号错误
 	if err != nil {
-		return err
+		return nil, err
 	}
-	// 修改bill的收款人和持票人
-	bill.PayBillID = args[1]
-	bill.PayBillName = args[2]
-	// 将修改完的bill存储回区块链
-	jsonString, err := json.Marshal(bill)
-	fmt.Println("json:" + string(jsonString))
-	if err != nil {
-		return errors.New("ChangeAccept-json序列化失败")
+	// 只有尚未承兑的票据才能更换承兑人
+	// The pay user can only be changed before the bill is accepted
+	if bill.State != BillInfo_State_Made {
+		return nil, &IllegalStateError{BillInfoID: billInfoID, From: bill.State, To: BillInfo_State_Made}
 	}
-	return ctx.GetStub().PutState(billid, []byte(jsonString))
-
+	if err := checkPartyID(payBillID); err != nil {
+		return nil, err
+	}
+	// 修改bill的承兑人
+	bill.PayBillID = payBillID
+	bill.PayBillName = payBillName
+	// 将修改完的bill存储回区块链
+	return putBill(ctx, bill)
 }
 
 // 由“做成” 到 “交付”  即更改票据状态 state. 由id查询，
@@ -779,22 +3046,26 @@ func (s *SmartContract) ChangeState(ctx contractapi.TransactionContextInterface,
 	}
 	// 以Bill的id查询票据
 	billid := args[0]
-	billinfo, err := ctx.GetStub().GetState(billid)
-	var bill Bill
-	err = json.Unmarshal([]byte(billinfo), &bill)
+	// 只有管理员可以直接修改票据状态
+	// Only admins can change the state directly
+	if err := authorizeRole(ctx, "change state of bill "+billid, Identity_Role_Admin); err != nil {
+		return err
+	}
+	bill, err := getBill(ctx, billid)
 	// 如果查询错误或为空，则说明账号错误
 	if err != nil {
 		return err
 	}
+	// 校验状态转换
+	// Validate the transition against the state machine
+	if err := checkTransition(bill, bill.State, args[1]); err != nil {
+		return err
+	}
 	// 修改bill的State
 	bill.State = args[1]
 	// 将修改完的bill存储回区块链
-	jsonString, err := json.Marshal(bill)
-	fmt.Println("json:" + string(jsonString))
-	if err != nil {
-		return errors.New("ChangeState-json序列化失败")
-	}
-	return ctx.GetStub().PutState(billid, []byte(jsonString))
+	_, err = putBill(ctx, bill)
+	return err
 }
 
 // 更改操作信息提示 message
@@ -805,9 +3076,12 @@ func (s *SmartContract) ChangeMessage(ctx contractapi.TransactionContextInterfac
 	}
 	// 以Bill的id查询票据
 	billid := args[0]
-	billinfo, err := ctx.GetStub().GetState(billid)
-	var bill Bill
-	err = json.Unmarshal([]byte(billinfo), &bill)
+	// 只有管理员可以直接修改提示信息
+	// Only admins can change the message directly
+	if err := authorizeRole(ctx, "change message of bill "+billid, Identity_Role_Admin); err != nil {
+		return err
+	}
+	bill, err := getBill(ctx, billid)
 	// 如果查询错误或为空，则说明账号错误
 	if err != nil {
 		return err
@@ -815,93 +3089,48 @@ func (s *SmartContract) ChangeMessage(ctx contractapi.TransactionContextInterfac
 	// 修改bill的Message
 	bill.Message = args[1]
 	// 将修改完的bill存储回区块链
-	jsonString, err := json.Marshal(bill)
-	fmt.Println("json:" + string(jsonString))
-	if err != nil {
-		return errors.New("ChangeMessage-json序列化失败")
-	}
-	return ctx.GetStub().PutState(billid, []byte(jsonString))
+	_, err = putBill(ctx, bill)
+	return err
 }
 
 // 查询个人票据信息 id (已经承兑)
 // Search all the bills' infos which have been paid
 func (s *SmartContract) QueryMyBillByIdAndPay(ctx contractapi.TransactionContextInterface, userid string) ([]Bill, error) {
-	uid := userid
-	state := "Public"
-	kk, _ := ctx.GetStub().CreateCompositeKey("searchkey", []string{uid, state})
-
-	resultsIterator, err := ctx.GetStub().GetHistoryForKey(kk)
-	if err != nil {
+	if err := authorizePartyQuery(ctx, userid); err != nil {
 		return nil, err
 	}
-	defer resultsIterator.Close()
-
-	results := []Bill{}
-
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
-		bill := Bill{}
-		_ = json.Unmarshal(queryResponse.Value, bill)
-		results = append(results, bill)
-	}
-
-	return results, nil
+	// 已承兑：作为承兑人且状态为 public
+	// Paid: the user is the pay party and the bill is public
+	return getBillsByIndex(ctx, IndexKey_Party, []string{Party_Pay, userid, BillInfo_State_Public})
 }
 
 // 查询个人票据信息 id (未承兑)
 // Search all the bills' infos which have not been paid
 func (s *SmartContract) QueryMyBillByIdAndUnpay(ctx contractapi.TransactionContextInterface, userid string) ([]Bill, error) {
-	uid := userid
-	state := "Made"
-	kk, _ := ctx.GetStub().CreateCompositeKey("searchkey", []string{uid, state})
-
-	resultsIterator, err := ctx.GetStub().GetHistoryForKey(kk)
-	if err != nil {
+	if err := authorizePartyQuery(ctx, userid); err != nil {
 		return nil, err
 	}
-	defer resultsIterator.Close()
-
-	results := []Bill{}
-
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
-		bill := Bill{}
-		_ = json.Unmarshal(queryResponse.Value, bill)
-		results = append(results, bill)
-	}
-
-	return results, nil
+	// 未承兑：作为承兑人且状态为 made
+	// Unpaid: the user is the pay party and the bill is still made
+	return getBillsByIndex(ctx, IndexKey_Party, []string{Party_Pay, userid, BillInfo_State_Made})
 }
 
 // 查询票据的历史信息
 // 查询所有票据信息 all
 // Query bill's operation history
-func (s *SmartContract) QueryBillHistoryById(ctx contractapi.TransactionContextInterface, billid string) ([]Bill, error) {
-	// 通过bill的id查询bill的历史记录
-	resultsIterator, err := ctx.GetStub().GetHistoryForKey(billid)
+func (s *SmartContract) QueryBillHistoryById(ctx contractapi.TransactionContextInterface, billid string) ([]HistoryEntry, error) {
+	bill, err := getBill(ctx, billid)
 	if err != nil {
-		return nil, errors.New("QueryBillHistoryById-查询历史记录失败")
+		return nil, err
 	}
-	defer resultsIterator.Close()
-
-	results := []Bill{}
-
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
-		bill := Bill{}
-		_ = json.Unmarshal(queryResponse.Value, bill)
-		results = append(results, bill)
+	if err := authorizeBillRead(ctx, bill); err != nil {
+		return nil, err
+	}
+	// 通过bill的id查询bill的历史记录
+	results, err := getBillHistory(ctx, billid)
+	if err != nil {
+		return nil, fmt.Errorf("QueryBillHistoryById-查询历史记录失败. %s", err.Error())
 	}
-
 	return results, nil
 }
 
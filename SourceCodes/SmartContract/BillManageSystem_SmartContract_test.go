@@ -0,0 +1,642 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// 试图改写查询条件的ID
+// IDs trying to rewrite the query they end up in
+var injectionPayloads = []string{
+	`acmid","State":{"$ne":""}`,
+	"acm\u0000id",
+	"acmid\u0000bill\u0000POA10000998",
+}
+
+// 测试用的客户端身份，证书属性直接给出
+// A client identity for tests, with the certificate attributes given directly
+type testIdentity struct {
+	mspID string
+	attrs map[string]string
+}
+
+func (identity *testIdentity) GetID() (string, error) {
+	return identity.attrs[Identity_Attr_CompanyID], nil
+}
+
+func (identity *testIdentity) GetMSPID() (string, error) {
+	return identity.mspID, nil
+}
+
+func (identity *testIdentity) GetAttributeValue(attrName string) (string, bool, error) {
+	value, found := identity.attrs[attrName]
+	return value, found, nil
+}
+
+func (identity *testIdentity) AssertAttributeValue(attrName string, attrValue string) error {
+	if value, _, _ := identity.GetAttributeValue(attrName); value != attrValue {
+		return fmt.Errorf("attribute %s is %q, not %q", attrName, value, attrValue)
+	}
+	return nil
+}
+
+func (identity *testIdentity) GetX509Certificate() (*x509.Certificate, error) {
+	return nil, nil
+}
+
+// 没有结果的迭代器
+// An iterator without results
+type emptyIterator struct{}
+
+func (iterator *emptyIterator) HasNext() bool { return false }
+
+func (iterator *emptyIterator) Next() (*queryresult.KV, error) {
+	return nil, errors.New("no more results")
+}
+
+func (iterator *emptyIterator) Close() error { return nil }
+
+// 记录 CouchDB 查询的测试桩，MockStub 本身不支持富查询
+// A stub recording the CouchDB queries, MockStub itself has no rich queries
+type queryRecordingStub struct {
+	*shimtest.MockStub
+	queries []string
+}
+
+func (stub *queryRecordingStub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	stub.queries = append(stub.queries, query)
+	return &emptyIterator{}, &peer.QueryResponseMetadata{}, nil
+}
+
+// MockStub 不支持分页，按 Fabric 的语义对组合键查询结果分页：书签为下一页的第一个key
+// MockStub has no pagination: page the composite key results the way Fabric does, the bookmark is the first key of the next page
+func (stub *queryRecordingStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resultsIterator.Close()
+	page := &sliceIterator{}
+	metadata := &peer.QueryResponseMetadata{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if queryResponse.Key < bookmark {
+			continue
+		}
+		if int32(len(page.results)) == pageSize {
+			metadata.Bookmark = queryResponse.Key
+			break
+		}
+		page.results = append(page.results, queryResponse)
+	}
+	metadata.FetchedRecordsCount = int32(len(page.results))
+	return page, metadata, nil
+}
+
+// 依次返回给定结果的迭代器
+// An iterator over the given results
+type sliceIterator struct {
+	results []*queryresult.KV
+}
+
+func (iterator *sliceIterator) HasNext() bool { return len(iterator.results) > 0 }
+
+func (iterator *sliceIterator) Next() (*queryresult.KV, error) {
+	if len(iterator.results) == 0 {
+		return nil, errors.New("no more results")
+	}
+	queryResponse := iterator.results[0]
+	iterator.results = iterator.results[1:]
+	return queryResponse, nil
+}
+
+func (iterator *sliceIterator) Close() error { return nil }
+
+// 以银行身份初始化账本，返回测试桩及交易上下文
+// Initialize the ledger as the bank, returning the stub and the transaction context
+func newTestContext(t *testing.T) (*queryRecordingStub, *contractapi.TransactionContext) {
+	stub := &queryRecordingStub{MockStub: shimtest.NewMockStub("bill", nil)}
+	stub.MockTransactionStart("init")
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(stub)
+	setCaller(ctx, "Org2MSP", "bank", Identity_Role_Bank)
+	err := new(SmartContract).InitLedger(ctx)
+	if err != nil {
+		t.Fatalf("InitLedger failed: %s", err)
+	}
+	return stub, ctx
+}
+
+// 切换调用者身份
+// Switch the caller's identity
+func setCaller(ctx *contractapi.TransactionContext, mspID string, companyID string, role string) {
+	attrs := map[string]string{Identity_Attr_CompanyID: companyID, Identity_Attr_CompanyName: companyID}
+	if role != "" {
+		attrs[Identity_Attr_Role] = role
+	}
+	ctx.SetClientIdentity(&testIdentity{mspID: mspID, attrs: attrs})
+}
+
+// 校验错误为指定参数的 InvalidArgumentError，确认是该参数的检查拒绝了请求
+// Check the error is an InvalidArgumentError of the given argument, so that argument's check is what rejected the call
+func expectInvalidArgument(t *testing.T, name string, err error, argument string) {
+	t.Helper()
+	var invalid *InvalidArgumentError
+	if !errors.As(err, &invalid) {
+		t.Errorf("%s: expected an InvalidArgumentError, got %v", name, err)
+	} else if invalid.Name != argument {
+		t.Errorf("%s: expected the %s to be rejected, got %v", name, argument, err)
+	}
+}
+
+func TestPartyQueriesRejectInjectedIDs(t *testing.T) {
+	_, ctx := newTestContext(t)
+	contract := new(SmartContract)
+	for _, payload := range injectionPayloads {
+		_, err := contract.QueryAllHoldBills(ctx, payload)
+		expectInvalidArgument(t, "QueryAllHoldBills "+payload, err, "company ID")
+		_, err = contract.QueryWaitPayBills(ctx, payload)
+		expectInvalidArgument(t, "QueryWaitPayBills "+payload, err, "company ID")
+		_, err = contract.QueryBillById(ctx, payload)
+		expectInvalidArgument(t, "QueryBillById "+payload, err, "bill ID")
+	}
+}
+
+func TestIssueBillRejectsInjectedIDs(t *testing.T) {
+	_, ctx := newTestContext(t)
+	contract := new(SmartContract)
+	// 出票日期和到期日期有效，只有注入的ID会被拒绝
+	// Valid issue and due dates, so only the injected ID can be rejected
+	now := time.Now().In(billLocation)
+	issueDate := now.Format(DateLayout)
+	dueDate := now.AddDate(0, 1, 0).Format(DateLayout)
+	issue := func(billID string, partyID string) error {
+		_, err := contract.IssueBill(ctx, billID, "100.00", DefaultCurrency, "A", issueDate, dueDate, partyID, "A公司", "bcmid", "B公司", "acmid", "A公司", "acmid", "A公司", false)
+		return err
+	}
+	for _, payload := range injectionPayloads {
+		expectInvalidArgument(t, "IssueBill bill ID "+payload, issue(payload, "acmid"), "bill ID")
+		expectInvalidArgument(t, "IssueBill drawer ID "+payload, issue("POD10000998", payload), "company ID")
+	}
+	if err := issue("POD10000998", "acmid"); err != nil {
+		t.Errorf("IssueBill with valid IDs failed: %s", err)
+	}
+}
+
+func TestSearchBillsRejectsInjectedIDs(t *testing.T) {
+	_, ctx := newTestContext(t)
+	contract := new(SmartContract)
+	for _, payload := range injectionPayloads {
+		for field, argument := range map[string]string{"partyId": "company ID", "idPrefix": "bill ID"} {
+			filter, _ := json.Marshal(map[string]string{field: payload})
+			_, err := contract.SearchBills(ctx, string(filter), 10, "")
+			expectInvalidArgument(t, "SearchBills "+field+" "+payload, err, argument)
+		}
+	}
+}
+
+func TestSearchBillsKeepsBillTypeLiteral(t *testing.T) {
+	stub, ctx := newTestContext(t)
+	contract := new(SmartContract)
+	for _, payload := range injectionPayloads {
+		filter, _ := json.Marshal(map[string]string{"billType": payload})
+		_, err := contract.SearchBills(ctx, string(filter), 10, "")
+		if err != nil {
+			t.Fatalf("SearchBills billType %q failed: %s", payload, err)
+		}
+		var query struct {
+			Selector map[string]interface{} `json:"selector"`
+		}
+		err = json.Unmarshal([]byte(stub.queries[len(stub.queries)-1]), &query)
+		if err != nil {
+			t.Fatalf("SearchBills built a malformed query: %s", err)
+		}
+		if query.Selector["BillInfoType"] != payload {
+			t.Errorf("billType %q became %v", payload, query.Selector["BillInfoType"])
+		}
+		if _, ok := query.Selector["State"]; ok {
+			t.Errorf("billType %q injected a State condition: %v", payload, query.Selector)
+		}
+	}
+}
+
+func TestSearchBillsScopesCompanies(t *testing.T) {
+	stub, ctx := newTestContext(t)
+	contract := new(SmartContract)
+	setCaller(ctx, "Org2MSP", "acmid", Identity_Role_Company)
+	_, err := contract.SearchBills(ctx, `{"partyId":"bcmid"}`, 10, "")
+	var unauthorized *UnauthorizedError
+	if !errors.As(err, &unauthorized) {
+		t.Errorf("a company searching another company's bills: expected an UnauthorizedError, got %v", err)
+	}
+	_, err = contract.SearchBills(ctx, "", 10, "")
+	if err != nil {
+		t.Fatalf("SearchBills failed: %s", err)
+	}
+	var query struct {
+		Selector map[string]interface{} `json:"selector"`
+	}
+	json.Unmarshal([]byte(stub.queries[len(stub.queries)-1]), &query)
+	if _, ok := query.Selector["$or"]; !ok {
+		t.Errorf("a company's search is not scoped to its own bills: %v", query.Selector)
+	}
+}
+
+func TestUnregisteredCallersAreRejected(t *testing.T) {
+	_, ctx := newTestContext(t)
+	contract := new(SmartContract)
+	callers := []struct {
+		name      string
+		mspID     string
+		companyID string
+		role      string
+	}{
+		{"no role", "Org2MSP", "acmid", ""},
+		{"claimed bank role", "Org2MSP", "acmid", Identity_Role_Bank},
+		{"other organization", "Org1MSP", "bank", Identity_Role_Bank},
+		{"unknown company", "Org2MSP", "zcmid", Identity_Role_Company},
+	}
+	for _, caller := range callers {
+		setCaller(ctx, caller.mspID, caller.companyID, caller.role)
+		var unauthorized *UnauthorizedError
+		if _, err := contract.QueryAllHoldBills(ctx, "bcmid"); !errors.As(err, &unauthorized) {
+			t.Errorf("%s: QueryAllHoldBills expected an UnauthorizedError, got %v", caller.name, err)
+		}
+		if _, err := contract.QueryAllBill(ctx); !errors.As(err, &unauthorized) {
+			t.Errorf("%s: QueryAllBill expected an UnauthorizedError, got %v", caller.name, err)
+		}
+		if err := contract.InitLedger(ctx); !errors.As(err, &unauthorized) {
+			t.Errorf("%s: InitLedger expected an UnauthorizedError, got %v", caller.name, err)
+		}
+	}
+}
+
+func TestEndorseBillRejectsTheHolder(t *testing.T) {
+	_, ctx := newTestContext(t)
+	setCaller(ctx, "Org2MSP", "acmid", Identity_Role_Company)
+	_, err := new(SmartContract).EndorseBill(ctx, "POA10000998", "acmid", "A公司", false)
+	var invalid *InvalidArgumentError
+	if !errors.As(err, &invalid) || invalid.Name != "endorsee" {
+		t.Errorf("endorsing a bill to its holder: expected an invalid endorsee, got %v", err)
+	}
+}
+
+func TestInitLedgerRequiresTheRegistryMSP(t *testing.T) {
+	stub := &queryRecordingStub{MockStub: shimtest.NewMockStub("bill", nil)}
+	stub.MockTransactionStart("init")
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(stub)
+	setCaller(ctx, "Org1MSP", "bank", Identity_Role_Bank)
+	var unauthorized *UnauthorizedError
+	if err := new(SmartContract).InitLedger(ctx); !errors.As(err, &unauthorized) {
+		t.Errorf("a bank of another MSP binding the registry: expected an UnauthorizedError, got %v", err)
+	}
+	if company, _ := getCompany(ctx, "acmid"); company != nil {
+		t.Errorf("the rejected initialization registered %v", company)
+	}
+}
+
+func TestRegisterCompany(t *testing.T) {
+	_, ctx := newTestContext(t)
+	contract := new(SmartContract)
+	company, err := contract.RegisterCompany(ctx, "dcmid", "D公司", Identity_Role_Company, "Org1MSP")
+	if err != nil {
+		t.Fatalf("RegisterCompany failed: %s", err)
+	}
+	if company.MSPID != "Org1MSP" || company.Role != Identity_Role_Company {
+		t.Errorf("registered %v", company)
+	}
+	// 新登记的公司可以以其MSP的证书调用
+	// The new company can call with a certificate of its MSP
+	setCaller(ctx, "Org1MSP", "dcmid", Identity_Role_Company)
+	if _, err := contract.QueryAllHoldBills(ctx, "dcmid"); err != nil {
+		t.Errorf("the registered company was rejected: %s", err)
+	}
+	var unauthorized *UnauthorizedError
+	if _, err := contract.RegisterCompany(ctx, "ecmid", "E公司", Identity_Role_Company, "Org1MSP"); !errors.As(err, &unauthorized) {
+		t.Errorf("a company registering another: expected an UnauthorizedError, got %v", err)
+	}
+	setCaller(ctx, "Org2MSP", "bank", Identity_Role_Bank)
+	if _, err := contract.RegisterCompany(ctx, "ebank", "E银行", Identity_Role_Bank, "Org1MSP"); !errors.As(err, &unauthorized) {
+		t.Errorf("a bank registering a bank: expected an UnauthorizedError, got %v", err)
+	}
+	var invalid *InvalidArgumentError
+	if _, err := contract.RegisterCompany(ctx, "acmid", "A公司", Identity_Role_Company, "Org1MSP"); !errors.As(err, &invalid) {
+		t.Errorf("registering a company again: expected an InvalidArgumentError, got %v", err)
+	}
+	if _, err := contract.RegisterCompany(ctx, "ecmid", "E公司", Identity_Role_Company, `Org1MSP","Role":"bank`); !errors.As(err, &invalid) || invalid.Name != "MSP ID" {
+		t.Errorf("a malformed MSP ID: expected an invalid MSP ID, got %v", err)
+	}
+}
+
+func TestAdminActsWithItsOwnIdentity(t *testing.T) {
+	_, ctx := newTestContext(t)
+	contract := new(SmartContract)
+	var unauthorized *UnauthorizedError
+	if err := contract.ChangeMessage(ctx, []string{"POA10000998", "checked"}); !errors.As(err, &unauthorized) {
+		t.Errorf("the bank changing a message: expected an UnauthorizedError, got %v", err)
+	}
+	setCaller(ctx, "Org2MSP", "admin", Identity_Role_Admin)
+	if err := contract.ChangeMessage(ctx, []string{"POA10000998", "checked"}); err != nil {
+		t.Fatalf("the registered admin was rejected: %s", err)
+	}
+	if _, err := contract.IssueBill(ctx, "POD10000998", "100.00", DefaultCurrency, "A", "2000-01-01", "2000-02-01", "bank", "银行", "bcmid", "B公司", "acmid", "A公司", "acmid", "A公司", false); !errors.As(err, &unauthorized) {
+		t.Errorf("the admin issuing a bill: expected an UnauthorizedError, got %v", err)
+	}
+}
+
+// 判断票据是否满足 $or 中的一个条件，只支持搜索生成的等值及 $elemMatch 条件
+// Whether the bill matches one clause of the $or, only the equality and $elemMatch clauses the search builds
+func matchesClause(document map[string]interface{}, clause map[string]interface{}) bool {
+	for field, condition := range clause {
+		if _, isString := condition.(string); isString {
+			if document[field] != condition {
+				return false
+			}
+			continue
+		}
+		elemMatch := condition.(map[string]interface{})["$elemMatch"].(map[string]interface{})
+		elements, _ := document[field].([]interface{})
+		found := false
+		for _, element := range elements {
+			matched := true
+			for key, value := range elemMatch {
+				matched = matched && element.(map[string]interface{})[key] == value
+			}
+			found = found || matched
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSearchPartiesMatchBillParties(t *testing.T) {
+	bill := &Bill{PubBillID: "p1", PayBillID: "p2", AcceptBillID: "p3", HoldBillID: "p4", EndorsedID: "p5", RecourseID: "p6",
+		Endorsements: []Endorsement{{EndorserID: "p7", EndorseeID: "p8"}}}
+	billAsBytes, _ := json.Marshal(bill)
+	var document map[string]interface{}
+	json.Unmarshal(billAsBytes, &document)
+	for _, partyID := range append(billParties(bill), "nobody") {
+		queryString, err := searchQuery(&BillSearch{PartyID: partyID})
+		if err != nil {
+			t.Fatalf("searchQuery failed: %s", err)
+		}
+		var query struct {
+			Selector map[string]interface{} `json:"selector"`
+		}
+		json.Unmarshal([]byte(queryString), &query)
+		found := false
+		for _, clause := range query.Selector["$or"].([]interface{}) {
+			found = found || matchesClause(document, clause.(map[string]interface{}))
+		}
+		if found != (partyID != "nobody") {
+			t.Errorf("searching party %q matches the bill: %v", partyID, found)
+		}
+	}
+}
+
+func TestNonPartiesCannotReadBills(t *testing.T) {
+	_, ctx := newTestContext(t)
+	contract := new(SmartContract)
+	// C公司不是票据 POB10000998 的当事人
+	// C公司 is no party of bill POB10000998
+	setCaller(ctx, "Org2MSP", "ccmid", Identity_Role_Company)
+	var unauthorized *UnauthorizedError
+	if _, err := contract.QueryBillById(ctx, "POB10000998"); !errors.As(err, &unauthorized) {
+		t.Errorf("QueryBillById by a non-party: expected an UnauthorizedError, got %v", err)
+	}
+	if _, err := contract.QueryHistoryById(ctx, "POB10000998"); !errors.As(err, &unauthorized) {
+		t.Errorf("QueryHistoryById by a non-party: expected an UnauthorizedError, got %v", err)
+	}
+	if _, err := contract.QueryBillHistoryById(ctx, "POB10000998"); !errors.As(err, &unauthorized) {
+		t.Errorf("QueryBillHistoryById by a non-party: expected an UnauthorizedError, got %v", err)
+	}
+	if _, err := contract.QueryAllHoldBills(ctx, "bcmid"); !errors.As(err, &unauthorized) {
+		t.Errorf("QueryAllHoldBills of another company: expected an UnauthorizedError, got %v", err)
+	}
+	if _, err := contract.QueryAllBill(ctx); !errors.As(err, &unauthorized) {
+		t.Errorf("QueryAllBill by a company: expected an UnauthorizedError, got %v", err)
+	}
+	// 票据的当事人可以查看
+	// A party of the bill can read it
+	setCaller(ctx, "Org2MSP", "bcmid", Identity_Role_Company)
+	if _, err := contract.QueryBillById(ctx, "POB10000998"); err != nil {
+		t.Errorf("QueryBillById by the holder failed: %s", err)
+	}
+}
+
+func TestLegacyAmountsAreFlagged(t *testing.T) {
+	stub, ctx := newTestContext(t)
+	contract := new(SmartContract)
+	// 旧版本不校验金额，写入一张金额无法识别的票据及其索引
+	// Older versions never checked the amount: store a bill with an amount that can't be parsed, with its index key
+	key, _ := stub.CreateCompositeKey(DocType_Bill, []string{"POX10000998"})
+	stub.PutState(key, []byte(`{"docType":"bill","BillInfoID":"POX10000998","BillInfoMoney":"-5","State":"public","HoldBillID":"acmid"}`))
+	indexKey, _ := stub.CreateCompositeKey(IndexKey_Party, []string{Party_Hold, "acmid", BillInfo_State_Public, "POX10000998"})
+	stub.PutState(indexKey, []byte{0x00})
+	before, err := contract.QueryAllBill(ctx)
+	if err != nil {
+		t.Fatalf("QueryAllBill with a legacy amount failed: %s", err)
+	}
+	setCaller(ctx, "Org2MSP", "acmid", Identity_Role_Company)
+	held, err := contract.QueryAllHoldBills(ctx, "acmid")
+	if err != nil {
+		t.Fatalf("QueryAllHoldBills with a legacy amount failed: %s", err)
+	}
+	for _, bill := range held {
+		if bill.BillInfoID == "POX10000998" {
+			t.Errorf("QueryAllHoldBills listed the bill with an invalid amount")
+		}
+	}
+	setCaller(ctx, "Org2MSP", "bank", Identity_Role_Bank)
+	flagged, err := contract.RebuildBillIndexes(ctx)
+	if err != nil {
+		t.Fatalf("RebuildBillIndexes failed: %s", err)
+	}
+	if len(flagged) != 1 || flagged[0] != "POX10000998" {
+		t.Errorf("RebuildBillIndexes flagged %v, expected [POX10000998]", flagged)
+	}
+	if isExisted(ctx, indexKey) {
+		t.Errorf("RebuildBillIndexes kept the index key of the flagged bill")
+	}
+	bill, err := contract.QueryBillById(ctx, "POX10000998")
+	if err != nil {
+		t.Fatalf("QueryBillById of the flagged bill failed: %s", err)
+	}
+	if bill.BillInfoMoney.Invalid != "-5" {
+		t.Errorf("flagged bill amount is %+v, expected the stored -5", bill.BillInfoMoney)
+	}
+	// 只有管理员可以更正金额，更正后票据重新出现在列表中
+	// Only an admin corrects the amount, afterwards the bill is listed again
+	if _, err := contract.CorrectBillAmount(ctx, "POX10000998", "5.00", DefaultCurrency); err == nil {
+		t.Errorf("CorrectBillAmount by the bank succeeded")
+	}
+	setCaller(ctx, "Org2MSP", "admin", Identity_Role_Admin)
+	if _, err := contract.CorrectBillAmount(ctx, "POA10000998", "5.00", DefaultCurrency); err == nil {
+		t.Errorf("CorrectBillAmount changed a bill with a valid amount")
+	}
+	if _, err := contract.CorrectBillAmount(ctx, "POX10000998", "5.00", DefaultCurrency); err != nil {
+		t.Fatalf("CorrectBillAmount failed: %s", err)
+	}
+	after, err := contract.QueryAllBill(ctx)
+	if err != nil {
+		t.Fatalf("QueryAllBill failed: %s", err)
+	}
+	if len(after) != len(before)+1 {
+		t.Errorf("QueryAllBill returned %d bills after the correction, expected %d", len(after), len(before)+1)
+	}
+}
+
+func TestRefreshMaturityAuthorizesBillParties(t *testing.T) {
+	_, ctx := newTestContext(t)
+	contract := new(SmartContract)
+	// C公司不是票据 POB10000998 的当事人
+	// C公司 is no party of bill POB10000998
+	setCaller(ctx, "Org2MSP", "ccmid", Identity_Role_Company)
+	var unauthorized *UnauthorizedError
+	if _, err := contract.RefreshMaturity(ctx, "POB10000998"); !errors.As(err, &unauthorized) {
+		t.Errorf("RefreshMaturity by a non-party: expected an UnauthorizedError, got %v", err)
+	}
+	setCaller(ctx, "Org2MSP", "admin", Identity_Role_Admin)
+	if _, err := contract.RefreshMaturity(ctx, "POB10000998"); err != nil {
+		t.Errorf("RefreshMaturity by an admin failed: %s", err)
+	}
+	// 被背书人也是票据的当事人
+	// The endorsee is a party of the bill as well
+	setCaller(ctx, "Org2MSP", "acmid", Identity_Role_Company)
+	if _, err := contract.EndorseBill(ctx, "POA10000998", "bcmid", "B公司", false); err != nil {
+		t.Fatalf("EndorseBill failed: %s", err)
+	}
+	setCaller(ctx, "Org2MSP", "bcmid", Identity_Role_Company)
+	if _, err := contract.RefreshMaturity(ctx, "POA10000998"); err != nil {
+		t.Errorf("RefreshMaturity by the endorsee failed: %s", err)
+	}
+}
+
+// 写入指定状态的票据
+// Store bills in the given states
+func putBillsInStates(t *testing.T, ctx *contractapi.TransactionContext, prefix string, bill Bill, states ...string) {
+	t.Helper()
+	for i, state := range states {
+		bill.BillInfoID = fmt.Sprintf("%s%08d", prefix, i)
+		bill.State = state
+		bill.BillInfoMoney = Amount{Fen: 100, Currency: DefaultCurrency}
+		if _, err := putBill(ctx, &bill); err != nil {
+			t.Fatalf("putBill %s failed: %s", bill.BillInfoID, err)
+		}
+	}
+}
+
+// 分页读取全部票据，返回各页的票据编号
+// Read every page, returning the bill IDs of each page
+func readAllPages(t *testing.T, name string, query func(bookmark string) (*PaginatedBills, error)) [][]string {
+	t.Helper()
+	var pages [][]string
+	bookmark := ""
+	for {
+		page, err := query(bookmark)
+		if err != nil {
+			t.Fatalf("%s failed: %s", name, err)
+		}
+		var ids []string
+		for _, bill := range page.Bills {
+			ids = append(ids, bill.BillInfoID)
+		}
+		pages = append(pages, ids)
+		if page.Bookmark == "" || len(pages) > 10 {
+			return pages
+		}
+		bookmark = page.Bookmark
+	}
+}
+
+func TestQueryDueBillsWithPagination(t *testing.T) {
+	_, ctx := newTestContext(t)
+	contract := new(SmartContract)
+	putBillsInStates(t, ctx, "DUE", Bill{HoldBillID: "acmid"}, BillInfo_State_Matured, BillInfo_State_Matured, BillInfo_State_Overdue, BillInfo_State_Matured, BillInfo_State_Overdue)
+	setCaller(ctx, "Org2MSP", "acmid", Identity_Role_Company)
+	pages := readAllPages(t, "QueryDueBillsWithPagination", func(bookmark string) (*PaginatedBills, error) {
+		return contract.QueryDueBillsWithPagination(ctx, "acmid", 2, bookmark)
+	})
+	// 到期票据在前，逾期票据在后，一页不足时由逾期票据补足
+	// Matured bills come first, overdue ones fill up the page they leave short
+	expected := "[[DUE00000000 DUE00000001] [DUE00000003 DUE00000002] [DUE00000004]]"
+	if fmt.Sprint(pages) != expected {
+		t.Errorf("QueryDueBillsWithPagination returned pages %v, expected %s", pages, expected)
+	}
+	_, err := contract.QueryDueBillsWithPagination(ctx, "acmid", 2, "7:")
+	expectInvalidArgument(t, "QueryDueBillsWithPagination with a bookmark of an unknown state", err, "bookmark")
+	var unauthorized *UnauthorizedError
+	if _, err := contract.QueryDueBillsWithPagination(ctx, "bcmid", 2, ""); !errors.As(err, &unauthorized) {
+		t.Errorf("QueryDueBillsWithPagination of another company: expected an UnauthorizedError, got %v", err)
+	}
+}
+
+func TestQueryWaitSettleBillsWithPagination(t *testing.T) {
+	_, ctx := newTestContext(t)
+	contract := new(SmartContract)
+	putBillsInStates(t, ctx, "SET", Bill{PayBillID: "ccmid", HoldBillID: "acmid"}, BillInfo_State_Presented, BillInfo_State_Settled, BillInfo_State_Presented, BillInfo_State_Presented)
+	setCaller(ctx, "Org2MSP", "ccmid", Identity_Role_Company)
+	pages := readAllPages(t, "QueryWaitSettleBillsWithPagination", func(bookmark string) (*PaginatedBills, error) {
+		return contract.QueryWaitSettleBillsWithPagination(ctx, "ccmid", 2, bookmark)
+	})
+	expected := "[[SET00000000 SET00000002] [SET00000003]]"
+	if fmt.Sprint(pages) != expected {
+		t.Errorf("QueryWaitSettleBillsWithPagination returned pages %v, expected %s", pages, expected)
+	}
+}
+
+func TestQueryWaitRecourseBillsWithPagination(t *testing.T) {
+	_, ctx := newTestContext(t)
+	contract := new(SmartContract)
+	putBillsInStates(t, ctx, "REC", Bill{RecourseID: "ccmid", HoldBillID: "acmid"}, BillInfo_State_Recourse, BillInfo_State_RecourseAgreed, BillInfo_State_Recourse, BillInfo_State_Recourse)
+	setCaller(ctx, "Org2MSP", "ccmid", Identity_Role_Company)
+	pages := readAllPages(t, "QueryWaitRecourseBillsWithPagination", func(bookmark string) (*PaginatedBills, error) {
+		return contract.QueryWaitRecourseBillsWithPagination(ctx, "ccmid", 2, bookmark)
+	})
+	expected := "[[REC00000000 REC00000002] [REC00000003]]"
+	if fmt.Sprint(pages) != expected {
+		t.Errorf("QueryWaitRecourseBillsWithPagination returned pages %v, expected %s", pages, expected)
+	}
+}
+
+func TestDiscountAndEndorseRecordsTheTransfer(t *testing.T) {
+	_, ctx := newTestContext(t)
+	contract := new(SmartContract)
+	setCaller(ctx, "Org2MSP", "admin", Identity_Role_Admin)
+	if err := contract.DiscountAndEndorse(ctx, []string{"POA10000998", "bcmid", "B公司", "bcmid", "B公司"}); err != nil {
+		t.Fatalf("DiscountAndEndorse failed: %s", err)
+	}
+	bill, err := getBill(ctx, "POA10000998")
+	if err != nil {
+		t.Fatalf("getBill failed: %s", err)
+	}
+	if len(bill.Endorsements) != 1 || bill.Endorsements[0].EndorserID != "acmid" || bill.Endorsements[0].EndorseeID != "bcmid" {
+		t.Errorf("DiscountAndEndorse recorded the endorsements %+v, expected one from acmid to bcmid", bill.Endorsements)
+	}
+	// 记载"不得转让"的票据不能转让
+	// A bill marked "not transferable" can't be transferred
+	bill.NonTransferable = true
+	if _, err := putBill(ctx, bill); err != nil {
+		t.Fatalf("putBill failed: %s", err)
+	}
+	var notTransferable *NotTransferableError
+	if err := contract.DiscountAndEndorse(ctx, []string{"POA10000998", "ccmid", "C公司", "ccmid", "C公司"}); !errors.As(err, &notTransferable) {
+		t.Errorf("DiscountAndEndorse of a non-transferable bill: expected a NotTransferableError, got %v", err)
+	}
+}